@@ -0,0 +1,151 @@
+package main
+
+import (
+	"github.com/M0hammadUsman/greenlight/internal/data"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+func (app *application) exportCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	usr, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	tokens, err := app.models.Tokens.GetAllMetadataForUser(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	apiKeys, err := app.models.APIKeys.GetAllForUser(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	permissions, err := app.models.Permissions.GetAllForUser(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	roles, err := app.models.Roles.GetAllForUser(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	orgs, err := app.models.Organizations.GetAllForUser(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	movies, err := app.models.Movies.GetAllCreatedBy(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	reviews, err := app.models.Reviews.GetAllByUser(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	lists, err := app.models.Lists.GetAllForUser(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	for _, list := range lists {
+		if list.Items, err = app.models.Lists.GetItems(list.ID, usr.ID); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	env := envelop{
+		"exported_at":   time.Now(),
+		"user":          usr,
+		"tokens":        tokens,
+		"api_keys":      apiKeys,
+		"permissions":   permissions,
+		"roles":         roles,
+		"organizations": orgs,
+		"movies":        movies,
+		"reviews":       reviews,
+		"lists":         lists,
+	}
+	headers := make(http.Header)
+	headers.Set("Content-Disposition", `attachment; filename="greenlight-export.json"`)
+	if err = app.writeJSON(w, env, http.StatusOK, headers); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	usr, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	var input struct {
+		Password string `json:"password"`
+	}
+	if err = app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if !app.passwordConfirmed(w, r, usr, input.Password) {
+		return
+	}
+	deleteAfter := time.Now().Add(app.config.accounts.deletionGrace)
+	if err = app.models.Users.ScheduleDeletion(usr.ID, deleteAfter); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = app.models.Tokens.DeleteAllScopesForUser(usr.ID); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = app.models.APIKeys.DeleteAllForUser(usr.ID); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.runInBackground(func() {
+		d := map[string]any{"deleteAfter": deleteAfter.UTC().Format(time.RFC1123)}
+		if err := app.mailer.Send(usr.Email, "account_deletion_scheduled.tmpl.html", d); err != nil {
+			slog.Error(err.Error())
+		}
+	})
+	env := envelop{
+		"message":      "your account is scheduled for deletion, log in again before then to cancel it",
+		"delete_after": deleteAfter,
+	}
+	if err = app.writeJSON(w, env, http.StatusAccepted, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// purgeDeletedUsers removes the accounts whose deletion grace period is over, it runs periodically under app.wg
+func (app *application) purgeDeletedUsers() {
+	emails, err := app.models.Users.PurgeScheduled()
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+	for _, email := range emails {
+		slog.Info("purged user account", "email", email)
+		if err = app.mailer.Send(email, "account_deleted.tmpl.html", nil); err != nil {
+			slog.Error(err.Error())
+		}
+	}
+}
+
+// cancelScheduledDeletion is called on every successful login, logging in is how a user takes a deletion back
+func (app *application) cancelScheduledDeletion(usr *data.User) error {
+	cancelled, err := app.models.Users.CancelDeletion(usr.ID)
+	if err != nil {
+		return err
+	}
+	if cancelled {
+		usr.Version++
+		slog.Info("cancelled scheduled account deletion", "user", usr.ID)
+	}
+	return nil
+}
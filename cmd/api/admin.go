@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"github.com/M0hammadUsman/greenlight/internal/data"
+	"github.com/M0hammadUsman/greenlight/internal/validator"
+	"github.com/tomasen/realip"
+	"log/slog"
+	"net/http"
+)
+
+// adminUser is how the admin endpoints show a user, with the version so updates can be pinned to it. Other responses
+// leave the version out.
+type adminUser struct {
+	*data.User
+	Version int `json:"version"`
+}
+
+func newAdminUser(usr *data.User) adminUser {
+	return adminUser{User: usr, Version: usr.Version}
+}
+
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name      string
+		Email     string
+		Activated *bool
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	input.Name = app.readString(qs, "name", "")
+	input.Email = app.readString(qs, "email", "")
+	input.Activated = app.readBool(qs, "activated", v)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafeList = []string{"id", "name", "email", "created_at", "-id", "-name", "-email", "-created_at"}
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	users, metadata, err := app.models.Users.GetAll(input.Name, input.Email, input.Activated, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	adminUsers := make([]adminUser, len(users))
+	for i, usr := range users {
+		adminUsers[i] = newAdminUser(usr)
+	}
+	if err = app.writeJSON(w, envelop{"users": adminUsers, "metadata": metadata}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readUserParam loads the user named by the id path parameter, writing the error response itself on failure
+func (app *application) readUserParam(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	usr, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return usr, true
+}
+
+func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	usr, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		Activated *bool `json:"activated"`
+		Version   *int  `json:"version"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	// The client may pin the version it last saw, otherwise the one just read is used
+	if input.Version != nil && *input.Version != usr.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+	if input.Activated != nil {
+		usr.Activated = *input.Activated
+	}
+	if err := app.models.Users.UpdateUser(usr); err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err := app.writeJSON(w, envelop{"user": newAdminUser(usr)}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// writeUserGrants responds with the user's direct grants, roles and the resulting effective permissions
+func (app *application) writeUserGrants(w http.ResponseWriter, r *http.Request, userID int64, version int) {
+	direct, err := app.models.Permissions.GetDirectForUser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	roles, err := app.models.Roles.GetAllForUser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	effective, err := app.models.Permissions.GetAllForUser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	env := envelop{
+		"permissions": direct,
+		"roles":       roles,
+		"effective":   effective,
+		"version":     version,
+	}
+	if err = app.writeJSON(w, env, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	usr, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+	app.writeUserGrants(w, r, usr.ID, usr.Version)
+}
+
+func (app *application) replaceUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	usr, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		Permissions []string `json:"permissions"`
+		Roles       []string `json:"roles"`
+		Version     *int     `json:"version"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	codes, err := app.models.Permissions.GetAllCodes()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	roleNames := make([]string, len(roles))
+	for i := range roles {
+		roleNames[i] = roles[i].Name
+	}
+	v := validator.New()
+	v.Check(input.Version != nil, "version", "must be provided")
+	v.Check(validator.Unique(input.Permissions), "permissions", "must not contain duplicate values")
+	for _, code := range input.Permissions {
+		v.Check(validator.In(code, codes...), "permissions", "must only contain known permission codes")
+	}
+	v.Check(validator.Unique(input.Roles), "roles", "must not contain duplicate values")
+	for _, role := range input.Roles {
+		v.Check(validator.In(role, roleNames...), "roles", "must only contain known roles")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	app.replaceUserGrants(w, r, usr.ID, *input.Version, input.Permissions, input.Roles)
+}
+
+func (app *application) deleteUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	usr, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		Version *int `json:"version"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if v.Check(input.Version != nil, "version", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	app.replaceUserGrants(w, r, usr.ID, *input.Version, nil, nil)
+}
+
+func (app *application) replaceUserGrants(w http.ResponseWriter, r *http.Request, userID int64, version int, codes, roles []string) {
+	version, err := app.models.Permissions.ReplaceForUser(userID, version, codes, roles)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.writeUserGrants(w, r, userID, version)
+}
+
+// impersonateUserHandler lets an admin see the API as the user does. The token it issues is short-lived, can't be
+// refreshed, and every request made with it ends up in the audit log. Other admins can't be impersonated, that would
+// let one admin act with another's identity.
+func (app *application) impersonateUserHandler(w http.ResponseWriter, r *http.Request) {
+	usr, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+	admin := app.contextGetUser(r)
+	if usr.ID == admin.ID {
+		app.badRequestResponse(w, r, errors.New("you can't impersonate yourself"))
+		return
+	}
+	permissions, err := app.models.Permissions.GetAllForUser(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if permissions.Include("users:admin") {
+		app.adminImpersonationResponse(w, r)
+		return
+	}
+	ip, userAgent := realip.FromRequest(r), r.UserAgent()
+	token, err := app.models.Tokens.NewImpersonation(usr.ID, admin.ID, app.config.auth.impersonateTTL, ip, userAgent)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	entry := &data.AuditEntry{
+		ActorID: admin.ID,
+		UserID:  usr.ID,
+		Action:  data.AuditImpersonationStarted,
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Status:  http.StatusCreated,
+		IP:      ip,
+	}
+	if err = app.models.Audit.Insert(entry); err != nil {
+		// An impersonation that isn't on record mustn't be usable
+		if delErr := app.models.Tokens.DeleteByHash(data.ScopeAuthentication, token.Hash); delErr != nil {
+			slog.Error(delErr.Error())
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	slog.Info("impersonation started", "admin_id", admin.ID, "user_id", usr.ID)
+	if err = app.writeJSON(w, envelop{"authentication_token": token, "user": newAdminUser(usr)}, http.StatusCreated, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
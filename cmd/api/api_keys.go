@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"github.com/M0hammadUsman/greenlight/internal/data"
+	"github.com/M0hammadUsman/greenlight/internal/validator"
+	"net/http"
+	"time"
+)
+
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	usr := app.contextGetUser(r)
+	var input struct {
+		Name        string     `json:"name"`
+		Permissions []string   `json:"permissions"`
+		Expiry      *time.Time `json:"expiry"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	permissions, err := app.models.Permissions.GetAllForUser(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	key := &data.APIKey{
+		UserID:      usr.ID,
+		Name:        input.Name,
+		Permissions: input.Permissions,
+		Expiry:      input.Expiry,
+	}
+	v := validator.New()
+	if data.ValidateAPIKey(v, key, permissions); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if err = app.models.APIKeys.New(key); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = app.writeJSON(w, envelop{"api_key": key}, http.StatusCreated, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	usr := app.contextGetUser(r)
+	keys, err := app.models.APIKeys.GetAllForUser(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = app.writeJSON(w, envelop{"api_keys": keys}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	usr := app.contextGetUser(r)
+	if err = app.models.APIKeys.DeleteForUser(id, usr.ID); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err = app.writeJSON(w, envelop{"message": "API key successfully revoked"}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
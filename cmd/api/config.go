@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 )
 
 type config struct {
@@ -30,6 +31,41 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	auth struct {
+		accessTokenTTL  time.Duration
+		refreshTokenTTL time.Duration
+		mode            string
+		signingAlg      string
+		signingKID      string
+		signingKeys     map[string]string
+		impersonateTTL  time.Duration
+	}
+	login struct {
+		accountThreshold int
+		ipThreshold      int
+		lockoutBase      time.Duration
+		lockoutMax       time.Duration
+		window           time.Duration
+		notify           bool
+	}
+	accounts struct {
+		deletionGrace time.Duration
+		inviteOnly    bool
+		invitationTTL time.Duration
+	}
+	password struct {
+		memory      uint
+		iterations  uint
+		parallelism uint
+		blocklist   string
+		breachedDir string
+	}
+	pagination struct {
+		cursorSecret string
+	}
+	movies struct {
+		trashRetention time.Duration
+	}
 }
 
 func parseConfigFlags() config {
@@ -56,6 +92,48 @@ func parseConfigFlags() config {
 		cfg.cors.trustedOrigins = strings.Fields(val)
 		return nil
 	})
+	// Authentication token flags. Signed access tokens are verified without the database, so logout, revoke-all,
+	// password resets, permission changes & account deletion only reach one already issued once it expires, i.e.
+	// within -auth-access-ttl. Their refresh tokens are revoked straight away.
+	flag.DurationVar(&cfg.auth.accessTokenTTL, "auth-access-ttl", 15*time.Minute, "Authentication (access) token lifetime")
+	flag.DurationVar(&cfg.auth.refreshTokenTTL, "auth-refresh-ttl", 30*24*time.Hour, "Refresh token lifetime")
+	flag.StringVar(&cfg.auth.mode, "auth-token-mode", "opaque", "Authentication token mode (opaque|signed)")
+	flag.StringVar(&cfg.auth.signingAlg, "auth-signing-alg", "HS256", "Signed token algorithm (HS256|EdDSA)")
+	flag.StringVar(&cfg.auth.signingKID, "auth-signing-kid", "", "ID of the key new signed tokens are signed with")
+	flag.Func("auth-signing-keys", "Signed token keys as base64 kid=key pairs (space separated)", func(val string) error {
+		cfg.auth.signingKeys = make(map[string]string)
+		for _, pair := range strings.Fields(val) {
+			kid, key, ok := strings.Cut(pair, "=")
+			if !ok || kid == "" {
+				return fmt.Errorf("invalid key pair %q", pair)
+			}
+			cfg.auth.signingKeys[kid] = key
+		}
+		return nil
+	})
+	flag.DurationVar(&cfg.auth.impersonateTTL, "auth-impersonation-ttl", 15*time.Minute, "Impersonation token lifetime")
+	// Login brute-force protection flags
+	flag.IntVar(&cfg.login.accountThreshold, "login-account-threshold", 5, "Failed logins before an account is locked")
+	flag.IntVar(&cfg.login.ipThreshold, "login-ip-threshold", 20, "Failed logins before an IP address is locked")
+	flag.DurationVar(&cfg.login.lockoutBase, "login-lockout-base", time.Minute, "First lockout length, doubled per further failure")
+	flag.DurationVar(&cfg.login.lockoutMax, "login-lockout-max", time.Hour, "Maximum length of a single lockout")
+	flag.DurationVar(&cfg.login.window, "login-window", 24*time.Hour, "How long failed logins are remembered")
+	flag.BoolVar(&cfg.login.notify, "login-lockout-notify", true, "Email users when their account gets locked")
+	// Account flags
+	flag.DurationVar(&cfg.accounts.deletionGrace, "account-deletion-grace", 30*24*time.Hour, "Grace period before a deleted account is purged")
+	flag.BoolVar(&cfg.accounts.inviteOnly, "account-invite-only", false, "Only allow registration with an invitation token")
+	flag.DurationVar(&cfg.accounts.invitationTTL, "account-invitation-ttl", 7*24*time.Hour, "Default invitation lifetime")
+	// Password hashing (Argon2id) flags
+	flag.UintVar(&cfg.password.memory, "password-argon2-memory", 64*1024, "Argon2id memory in KiB")
+	flag.UintVar(&cfg.password.iterations, "password-argon2-iterations", 3, "Argon2id iterations")
+	flag.UintVar(&cfg.password.parallelism, "password-argon2-parallelism", 2, "Argon2id parallelism")
+	// Password policy flags, both checks run against local files only
+	flag.StringVar(&cfg.password.blocklist, "password-blocklist", "", "Common passwords file, one per line (loaded into a bloom filter)")
+	flag.StringVar(&cfg.password.breachedDir, "password-breached-dir", "", "Directory of Pwned Passwords range files (<SHA-1 prefix>.txt)")
+	// Keyset pagination flags
+	flag.StringVar(&cfg.pagination.cursorSecret, "pagination-cursor-secret", "", "Base64 key cursors are signed with, at least 32 bytes & the same on every instance (required)")
+	// Movie flags
+	flag.DurationVar(&cfg.movies.trashRetention, "movies-trash-retention", 30*24*time.Hour, "How long deleted movies stay in the trash before they're purged")
 	// Show version flag
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 	// parsing flags
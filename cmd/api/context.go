@@ -3,13 +3,21 @@ package main
 import (
 	"context"
 	"github.com/M0hammadUsman/greenlight/internal/data"
+	"github.com/M0hammadUsman/greenlight/internal/jwt"
 	"net/http"
 )
 
 type contextKey string
 
 // Just to be safe from key collision in Request Contexts
-const userContextKey = contextKey("user")
+const (
+	userContextKey      = contextKey("user")
+	tokenHashContextKey = contextKey("tokenHash")
+	claimsContextKey    = contextKey("claims")
+	apiKeyContextKey    = contextKey("apiKey")
+	orgContextKey       = contextKey("organization")
+	orgPermsContextKey  = contextKey("organizationPermissions")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -23,3 +31,65 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// contextSetTokenHash stores the hash of the bearer token the request was authenticated with
+func (app *application) contextSetTokenHash(r *http.Request, hash []byte) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenHashContextKey, hash)
+	return r.WithContext(ctx)
+}
+
+// contextGetTokenHash returns nil for requests that weren't authenticated with a bearer token
+func (app *application) contextGetTokenHash(r *http.Request) []byte {
+	hash, _ := r.Context().Value(tokenHashContextKey).([]byte)
+	return hash
+}
+
+func (app *application) contextSetClaims(r *http.Request, claims *jwt.Claims) *http.Request {
+	ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+	return r.WithContext(ctx)
+}
+
+// contextGetClaims returns nil for requests that weren't authenticated with a signed access token
+func (app *application) contextGetClaims(r *http.Request) *jwt.Claims {
+	claims, _ := r.Context().Value(claimsContextKey).(*jwt.Claims)
+	return claims
+}
+
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey returns nil for requests that weren't authenticated with an API key
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+// contextSetOrganization stores the organization the request is scoped to, with what the user holds within it
+func (app *application) contextSetOrganization(r *http.Request, org *data.Organization, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), orgContextKey, org)
+	ctx = context.WithValue(ctx, orgPermsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+// contextGetOrganization returns nil for requests that aren't scoped to an organization
+func (app *application) contextGetOrganization(r *http.Request) *data.Organization {
+	org, _ := r.Context().Value(orgContextKey).(*data.Organization)
+	return org
+}
+
+func (app *application) contextGetOrganizationPermissions(r *http.Request) data.Permissions {
+	permissions, _ := r.Context().Value(orgPermsContextKey).(data.Permissions)
+	return permissions
+}
+
+// currentUser returns the full user record behind the request. Signed access tokens only carry the user's ID,
+// activation state & permissions, so in that case the record is loaded from the database.
+func (app *application) currentUser(r *http.Request) (*data.User, error) {
+	usr := app.contextGetUser(r)
+	if app.contextGetClaims(r) == nil {
+		return usr, nil
+	}
+	return app.models.Users.Get(usr.ID)
+}
@@ -4,6 +4,7 @@ import (
 	"log/slog"
 	"net/http"
 	"runtime/debug"
+	"time"
 )
 
 func (app *application) logError(_ *http.Request, err error) {
@@ -48,6 +49,20 @@ func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
 
+func (app *application) tooManyLoginAttemptsResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	message := "too many failed login attempts, please try again later"
+	if err := app.writeJSON(w, envelop{"error": message}, http.StatusTooManyRequests, retryAfterHeader(retryAfter)); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) accountLockedResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	message := "this account is temporarily locked due to too many failed login attempts"
+	if err := app.writeJSON(w, envelop{"error": message}, http.StatusLocked, retryAfterHeader(retryAfter)); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 func (app *application) invalidCredentialResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
@@ -59,6 +74,11 @@ func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+func (app *application) invalidAPIKeyResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or expired API key"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
 	message := "you must be authenticated to access this resource"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
@@ -68,7 +88,27 @@ func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.R
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
+func (app *application) mfaAlreadyEnabledResponse(w http.ResponseWriter, r *http.Request) {
+	message := "two-factor authentication is already enabled for your account"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) impersonationNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this action is not available while impersonating a user"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) adminImpersonationResponse(w http.ResponseWriter, r *http.Request) {
+	message := "users with the users:admin permission can't be impersonated"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) apiKeyNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource can't be accessed with an API key"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/M0hammadUsman/greenlight/internal/data"
 	"github.com/M0hammadUsman/greenlight/internal/validator"
 	"io"
 	"log/slog"
@@ -11,12 +12,18 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func (app *application) readIDParam(r *http.Request) (int64, error) {
-	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	return app.readPathID(r, "id")
+}
+
+// readPathID parses the named path parameter as a positive ID
+func (app *application) readPathID(r *http.Request, name string) (int64, error) {
+	id, err := strconv.ParseInt(r.PathValue(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id param")
+		return 0, fmt.Errorf("invalid %s param", name)
 	}
 	return id, nil
 }
@@ -111,6 +118,40 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// readBool returns nil when the key is absent, so callers can tell "not filtered" apart from false
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return nil
+	}
+	return &b
+}
+
+// passwordConfirmed checks a re-entered password for sensitive actions, writing the error response itself on failure
+func (app *application) passwordConfirmed(w http.ResponseWriter, r *http.Request, usr *data.User, password string) bool {
+	v := validator.New()
+	if v.Check(password != "", "password", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+	match, err := usr.Password.Matches(password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if !match {
+		v.AddError("password", "does not match your current password")
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+	return true
+}
+
 func (app *application) runInBackground(fn func()) {
 	app.wg.Add(1)
 	go func() {
@@ -123,3 +164,28 @@ func (app *application) runInBackground(fn func()) {
 		fn()
 	}()
 }
+
+// runPeriodically calls fn every interval until the application starts shutting down
+func (app *application) runPeriodically(interval time.Duration, fn func()) {
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-app.shutdown:
+				return
+			case <-ticker.C:
+				func() {
+					defer func() {
+						if err := recover(); err != nil {
+							slog.Error(fmt.Errorf("%v", err).Error())
+						}
+					}()
+					fn()
+				}()
+			}
+		}
+	}()
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"github.com/M0hammadUsman/greenlight/internal/data"
+	"github.com/M0hammadUsman/greenlight/internal/validator"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (app *application) createInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email       string     `json:"email"`
+		Permissions []string   `json:"permissions"`
+		Expiry      *time.Time `json:"expiry"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	codes, err := app.models.Permissions.GetAllCodes()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	admin := app.contextGetUser(r)
+	inv := &data.Invitation{
+		CreatedBy:   &admin.ID,
+		Email:       input.Email,
+		Permissions: input.Permissions,
+		Expiry:      time.Now().Add(app.config.accounts.invitationTTL),
+	}
+	if inv.Permissions == nil {
+		inv.Permissions = data.Permissions{}
+	}
+	if input.Expiry != nil {
+		inv.Expiry = *input.Expiry
+	}
+	v := validator.New()
+	if data.ValidateInvitation(v, inv, codes); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if _, err = app.models.Users.GetByEmail(inv.Email); err == nil {
+		v.AddError("email", "a user with this email address already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	} else if !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = app.models.Invitations.New(inv); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.runInBackground(func() {
+		d := map[string]any{
+			"invitationToken": inv.PlainText,
+			"expiry":          inv.Expiry.Format(time.RFC1123),
+		}
+		if err := app.mailer.Send(inv.Email, "invitation.tmpl.html", d); err != nil {
+			slog.Error(err.Error())
+		}
+	})
+	if err = app.writeJSON(w, envelop{"invitation": inv}, http.StatusCreated, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	invitations, err := app.models.Invitations.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = app.writeJSON(w, envelop{"invitations": invitations}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	if err = app.models.Invitations.Delete(id); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err = app.writeJSON(w, envelop{"message": "invitation successfully revoked"}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// registerInvitedUser is the part of registerUserHandler that handles sign-ups carrying an invitation token.
+// The invitation vouches for the email address, so the user starts out activated.
+func (app *application) registerInvitedUser(w http.ResponseWriter, r *http.Request, user *data.User, password, token string) {
+	v := validator.New()
+	if data.ValidateTokenPlainText(v, token); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	inv, err := app.models.Invitations.GetForPlainText(token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired invitation token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if user.Email == "" {
+		user.Email = inv.Email
+	}
+	v.Check(strings.EqualFold(user.Email, inv.Email), "email", "must match the address the invitation was sent to")
+	user.Activated = true
+	data.ValidateUser(v, user)
+	if err = data.ValidatePasswordPolicy(v, app.models.Users.PasswordPolicy, password, user); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if err = app.models.Invitations.Accept(inv, user); err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired invitation token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err = app.models.Organizations.AddMemberBySlug(data.DefaultOrganization, user.ID); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = app.writeJSON(w, envelop{"user": user}, http.StatusCreated, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
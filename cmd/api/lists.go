@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/M0hammadUsman/greenlight/internal/data"
+	"github.com/M0hammadUsman/greenlight/internal/validator"
+	"net/http"
+)
+
+func (app *application) createListHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Public      bool   `json:"public"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	list := &data.List{
+		UserID:      app.contextGetUser(r).ID,
+		Name:        input.Name,
+		Description: input.Description,
+		Public:      input.Public,
+	}
+	v := validator.New()
+	if data.ValidateList(v, list); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if err := app.models.Lists.Insert(list); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/users/me/lists/%v", list.ID))
+	if err := app.writeJSON(w, envelop{"list": list}, http.StatusCreated, headers); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listCurrentUserListsHandler(w http.ResponseWriter, r *http.Request) {
+	lists, err := app.models.Lists.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = app.writeJSON(w, envelop{"lists": lists}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readListParam loads the current user's list named by the id path parameter, writing the error response itself on
+// failure. Other users' lists are reported as not found, public or not.
+func (app *application) readListParam(w http.ResponseWriter, r *http.Request) (*data.List, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	list, err := app.models.Lists.GetForUser(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return list, true
+}
+
+// writeListWithItems responds with the list & the items the request's user may see, in order
+func (app *application) writeListWithItems(w http.ResponseWriter, r *http.Request, list *data.List) {
+	items, err := app.models.Lists.GetItems(list.ID, app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	list.Items = items
+	if err = app.writeJSON(w, envelop{"list": list}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showListHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := app.readListParam(w, r)
+	if !ok {
+		return
+	}
+	app.writeListWithItems(w, r, list)
+}
+
+func (app *application) showPublicListHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	list, err := app.models.Lists.GetPublic(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.writeListWithItems(w, r, list)
+}
+
+func (app *application) updateListHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := app.readListParam(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+		Public      *bool   `json:"public"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Name != nil {
+		list.Name = *input.Name
+	}
+	if input.Description != nil {
+		list.Description = *input.Description
+	}
+	if input.Public != nil {
+		list.Public = *input.Public
+	}
+	v := validator.New()
+	if data.ValidateList(v, list); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if err := app.models.Lists.Update(list); err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err := app.writeJSON(w, envelop{"list": list}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteListHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	if err = app.models.Lists.Delete(id, app.contextGetUser(r).ID); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err = app.writeJSON(w, envelop{"message": "list successfully deleted"}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// addListItemHandler appends a movie to the list, the movie must be readable in the request's organization
+func (app *application) addListItemHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := app.readListParam(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		MovieID int64  `json:"movie_id"`
+		Note    string `json:"note"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.MovieID > 0, "movie_id", "must be provided")
+	if data.ValidateListItemNote(v, input.Note); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if _, err := app.models.Movies.Get(app.contextGetOrganization(r).ID, input.MovieID); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("movie_id", "no matching movie found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err := app.models.Lists.AddItem(list.ID, input.MovieID, input.Note); err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateListItem):
+			v.AddError("movie_id", "this movie is already on the list")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.writeListWithItems(w, r, list)
+}
+
+func (app *application) removeListItemHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := app.readListParam(w, r)
+	if !ok {
+		return
+	}
+	movieID, err := app.readPathID(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	if err = app.models.Lists.RemoveItem(list.ID, movieID); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.writeListWithItems(w, r, list)
+}
+
+// reorderListItemsHandler takes every movie shown on the list, in the order they should be shown
+func (app *application) reorderListItemsHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := app.readListParam(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		MovieIDs []int64 `json:"movie_ids"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.MovieIDs != nil, "movie_ids", "must be provided")
+	v.Check(validator.Unique(input.MovieIDs), "movie_ids", "must not contain duplicate values")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if err := app.models.Lists.Reorder(list, input.MovieIDs); err != nil {
+		switch {
+		case errors.Is(err, data.ErrListOrderMismatch):
+			v.AddError("movie_ids", "must contain every movie on the list exactly once")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.writeListWithItems(w, r, list)
+}
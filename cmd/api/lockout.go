@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/M0hammadUsman/greenlight/internal/data"
+	"github.com/tomasen/realip"
+	"log/slog"
+	"math"
+	"net/http"
+	"time"
+)
+
+func (app *application) accountLockoutPolicy() data.LockoutPolicy {
+	return data.LockoutPolicy{
+		Threshold: app.config.login.accountThreshold,
+		Base:      app.config.login.lockoutBase,
+		Max:       app.config.login.lockoutMax,
+		Window:    app.config.login.window,
+	}
+}
+
+func (app *application) ipLockoutPolicy() data.LockoutPolicy {
+	p := app.accountLockoutPolicy()
+	p.Threshold = app.config.login.ipThreshold
+	return p
+}
+
+// loginLocked writes a 429 response if the client's IP is locked out, or a 423 one if the account is, and reports
+// whether it did so
+func (app *application) loginLocked(w http.ResponseWriter, r *http.Request, email string) bool {
+	lockedUntil, err := app.models.Logins.LockedUntil(data.IPAttemptKey(realip.FromRequest(r)))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return true
+	}
+	if lockedUntil != nil {
+		app.tooManyLoginAttemptsResponse(w, r, time.Until(*lockedUntil))
+		return true
+	}
+	if lockedUntil, err = app.models.Logins.LockedUntil(data.AccountAttemptKey(email)); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return true
+	}
+	if lockedUntil != nil {
+		app.accountLockedResponse(w, r, time.Until(*lockedUntil))
+		return true
+	}
+	return false
+}
+
+// recordLoginFailure counts a failed login against both the client's IP and the account, usr is nil when no account
+// matches the email. The owner is notified the moment the account gets locked for the first time.
+func (app *application) recordLoginFailure(r *http.Request, email string, usr *data.User) error {
+	ip := realip.FromRequest(r)
+	if _, _, err := app.models.Logins.RecordFailure(data.IPAttemptKey(ip), app.ipLockoutPolicy()); err != nil {
+		return err
+	}
+	policy := app.accountLockoutPolicy()
+	failures, lockedUntil, err := app.models.Logins.RecordFailure(data.AccountAttemptKey(email), policy)
+	if err != nil {
+		return err
+	}
+	if usr != nil && lockedUntil != nil && failures == policy.Threshold && app.config.login.notify {
+		app.runInBackground(func() {
+			d := map[string]any{
+				"failures":    failures,
+				"ip":          ip,
+				"lockedUntil": lockedUntil.UTC().Format(time.RFC1123),
+			}
+			if err := app.mailer.Send(usr.Email, "account_locked.tmpl.html", d); err != nil {
+				slog.Error(err.Error())
+			}
+		})
+	}
+	return nil
+}
+
+// maxMFACodeAttempts is how many wrong codes an mfa pending token survives
+const maxMFACodeAttempts = 5
+
+// recordMFAFailure counts a wrong second factor against the account like a wrong password, so the account lockout
+// covers it too. The pending token is deleted after maxMFACodeAttempts, one password entry can't be used to guess
+// the code for long.
+func (app *application) recordMFAFailure(r *http.Request, mfaToken string, usr *data.User) error {
+	if err := app.recordLoginFailure(r, usr.Email, usr); err != nil {
+		return err
+	}
+	key := data.MFATokenAttemptKey(mfaToken)
+	failures, _, err := app.models.Logins.RecordFailure(key, data.LockoutPolicy{Window: app.config.login.window})
+	if err != nil {
+		return err
+	}
+	if failures < maxMFACodeAttempts {
+		return nil
+	}
+	err = app.models.Tokens.DeleteByHash(data.ScopeMFAPending, data.HashTokenPlainText(mfaToken))
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		return err
+	}
+	return app.models.Logins.Reset(key)
+}
+
+func (app *application) deleteStaleLoginAttempts() {
+	if err := app.models.Logins.DeleteStale(app.config.login.window); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+func retryAfterHeader(retryAfter time.Duration) http.Header {
+	headers := make(http.Header)
+	headers.Set("Retry-After", fmt.Sprint(int(math.Ceil(retryAfter.Seconds()))))
+	return headers
+}
@@ -2,15 +2,19 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"expvar"
 	"fmt"
 	"github.com/M0hammadUsman/greenlight/internal/data"
+	"github.com/M0hammadUsman/greenlight/internal/jwt"
 	"github.com/M0hammadUsman/greenlight/internal/mailer"
+	"github.com/M0hammadUsman/greenlight/internal/validator"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/lmittmann/tint"
 	"log"
 	"log/slog"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
@@ -29,10 +33,16 @@ var buildTime string
 
 // Dependencies lives here for the application
 type application struct {
-	config config
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config   config
+	models   data.Models
+	mailer   mailer.Mailer
+	lastUsed *lastUsedTracker
+	signer   *jwt.KeySet // nil unless signed access tokens are enabled
+	// cursorKey signs pagination cursors
+	cursorKey []byte
+	wg        sync.WaitGroup
+	// shutdown is closed once the server stops accepting requests, periodic background jobs return on it
+	shutdown chan struct{}
 }
 
 func main() {
@@ -41,6 +51,14 @@ func main() {
 	// Loggers configuration
 	configureLoggers()
 
+	params, err := newArgon2idParams(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	policy, err := newPasswordPolicy(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
 	// DB configuration
 	db, err := openDB(cfg)
 	if err != nil {
@@ -50,10 +68,23 @@ func main() {
 	slog.Info("database connection pool established")
 
 	app := &application{
-		config: cfg,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:   cfg,
+		models:   data.NewModels(db, params, policy),
+		mailer:   mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		lastUsed: newLastUsedTracker(),
+		shutdown: make(chan struct{}),
+	}
+	if app.signer, err = newSigner(cfg); err != nil {
+		log.Fatal(err)
 	}
+	if app.cursorKey, err = newCursorKey(cfg); err != nil {
+		log.Fatal(err)
+	}
+	// Background jobs
+	app.runPeriodically(time.Minute, app.flushLastUsed)
+	app.runPeriodically(time.Hour, app.deleteStaleLoginAttempts)
+	app.runPeriodically(time.Hour, app.purgeDeletedUsers)
+	app.runPeriodically(time.Hour, app.purgeTrashedMovies)
 	//Exposing custom metrics
 	exposeCustomMetrics(db)
 	// Starting server
@@ -62,6 +93,81 @@ func main() {
 	}
 }
 
+func newSigner(cfg config) (*jwt.KeySet, error) {
+	switch cfg.auth.mode {
+	case "opaque":
+		return nil, nil
+	case "signed":
+	default:
+		return nil, fmt.Errorf("unknown auth token mode %q", cfg.auth.mode)
+	}
+	keys := make([]jwt.Key, 0, len(cfg.auth.signingKeys))
+	for kid, encoded := range cfg.auth.signingKeys {
+		key, err := jwt.ParseKey(kid, cfg.auth.signingAlg, encoded)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return jwt.NewKeySet(cfg.auth.signingKID, keys...)
+}
+
+// newCursorKey decodes the configured cursor secret. It's required, every replica has to sign with the same key for
+// cursors to keep working across instances & restarts.
+func newCursorKey(cfg config) ([]byte, error) {
+	if cfg.pagination.cursorSecret == "" {
+		return nil, errors.New("pagination cursor secret must be provided")
+	}
+	key, err := base64.StdEncoding.DecodeString(cfg.pagination.cursorSecret)
+	if err != nil {
+		return nil, fmt.Errorf("pagination cursor secret: %w", err)
+	}
+	if len(key) < 32 {
+		return nil, errors.New("pagination cursor secret must be at least 32 bytes long")
+	}
+	return key, nil
+}
+
+// newArgon2idParams checks the argon2 flags fit their types & argon2's own limits, out of range values would otherwise
+// wrap around or make hashing panic on the first login
+func newArgon2idParams(cfg config) (data.Argon2idParams, error) {
+	p := cfg.password
+	switch {
+	case p.parallelism < 1 || p.parallelism > math.MaxUint8:
+		return data.Argon2idParams{}, fmt.Errorf("password argon2 parallelism must be between 1 and %d", math.MaxUint8)
+	case p.iterations < 1 || p.iterations > math.MaxUint32:
+		return data.Argon2idParams{}, fmt.Errorf("password argon2 iterations must be between 1 and %d", uint32(math.MaxUint32))
+	case p.memory < 8*p.parallelism || p.memory > math.MaxUint32:
+		return data.Argon2idParams{}, fmt.Errorf("password argon2 memory must be between 8*parallelism (%d) and %d KiB",
+			8*p.parallelism, uint32(math.MaxUint32))
+	}
+	return data.Argon2idParams{
+		Memory:      uint32(p.memory),
+		Iterations:  uint32(p.iterations),
+		Parallelism: uint8(p.parallelism),
+		SaltLength:  16,
+		KeyLength:   32,
+	}, nil
+}
+
+func newPasswordPolicy(cfg config) (data.PasswordPolicy, error) {
+	var policy data.PasswordPolicy
+	if cfg.password.blocklist != "" {
+		bf, err := validator.LoadBloomFilter(cfg.password.blocklist, 0.001)
+		if err != nil {
+			return data.PasswordPolicy{}, err
+		}
+		policy.Checkers = append(policy.Checkers, bf)
+	}
+	if cfg.password.breachedDir != "" {
+		if _, err := os.Stat(cfg.password.breachedDir); err != nil {
+			return data.PasswordPolicy{}, err
+		}
+		policy.Checkers = append(policy.Checkers, validator.HashPrefixDir{Dir: cfg.password.breachedDir})
+	}
+	return policy, nil
+}
+
 func configureLoggers() {
 	tintHandler := tint.NewHandler(os.Stderr, &tint.Options{
 		AddSource: true,
@@ -129,7 +235,9 @@ func (app *application) serve() error {
 			shutdownError <- err
 		}
 		slog.Info("completing background tasks", "addr", srv.Addr)
+		close(app.shutdown)
 		app.wg.Wait()
+		app.flushLastUsed() // Persist whatever was seen since the last periodic flush
 		shutdownError <- nil
 	}() // Start the server normally
 	slog.Info("starting server", "env", app.config.env, "port", app.config.port)
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"github.com/M0hammadUsman/greenlight/internal/data"
+	"github.com/M0hammadUsman/greenlight/internal/totp"
+	"github.com/M0hammadUsman/greenlight/internal/validator"
+	"net/http"
+	"time"
+)
+
+func (app *application) enrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	usr, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = app.models.MFA.Enroll(usr.ID, secret); err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.mfaAlreadyEnabledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	env := envelop{"totp": map[string]string{
+		"secret": secret,
+		"uri":    totp.URI("Greenlight", usr.Email, secret),
+	}}
+	if err = app.writeJSON(w, env, http.StatusCreated, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	usr := app.contextGetUser(r)
+	var input struct {
+		Code string `json:"code"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if v.Check(input.Code != "", "code", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	enrollment, err := app.models.MFA.GetTOTP(usr.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("code", "no pending two-factor enrollment, start one first")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if enrollment.Confirmed {
+		app.mfaAlreadyEnabledResponse(w, r)
+		return
+	}
+	step, ok := totp.Validate(enrollment.Secret, input.Code, time.Now())
+	if !ok {
+		v.AddError("code", "invalid or expired authentication code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	codes, err := data.GenerateRecoveryCodes()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = app.models.MFA.Confirm(usr.ID, step, codes); err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.mfaAlreadyEnabledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err = app.writeJSON(w, envelop{"recovery_codes": codes}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) disableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	usr, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	var input struct {
+		Password string `json:"password"`
+	}
+	if err = app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if !app.passwordConfirmed(w, r, usr, input.Password) {
+		return
+	}
+	if err = app.models.MFA.Disable(usr.ID); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	env := envelop{"message": "two-factor authentication successfully disabled"}
+	if err = app.writeJSON(w, env, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	usr, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	var input struct {
+		Password string `json:"password"`
+	}
+	if err = app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if !app.passwordConfirmed(w, r, usr, input.Password) {
+		return
+	}
+	enrollment, err := app.models.MFA.GetTOTP(usr.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if enrollment == nil || !enrollment.Confirmed {
+		app.notFoundResponse(w, r)
+		return
+	}
+	codes, err := data.GenerateRecoveryCodes()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = app.models.MFA.ReplaceRecoveryCodes(usr.ID, codes); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = app.writeJSON(w, envelop{"recovery_codes": codes}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createMFAAuthenticationTokenHandler exchanges the mfa pending token issued by createAuthenticationTokenHandler,
+// plus a TOTP or recovery code, for a real authentication token
+func (app *application) createMFAAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	data.ValidateTokenPlainText(v, input.MFAToken)
+	v.Check(input.Code != "" || input.RecoveryCode != "", "code", "either code or recovery_code must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	usr, err := app.models.Users.GetForToken(data.ScopeMFAPending, input.MFAToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("mfa_token", "invalid or expired mfa token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if app.loginLocked(w, r, usr.Email) {
+		return
+	}
+	if input.RecoveryCode != "" {
+		err = app.models.MFA.UseRecoveryCode(usr.ID, input.RecoveryCode)
+	} else {
+		err = app.useTOTPCode(usr.ID, input.Code)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound), errors.Is(err, data.ErrEditConflict):
+			if err = app.recordMFAFailure(r, input.MFAToken, usr); err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			v.AddError("code", "invalid or expired authentication code")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err = app.models.Tokens.DeleteAllForUser(data.ScopeMFAPending, usr.ID); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	for _, key := range []string{data.AccountAttemptKey(usr.Email), data.MFATokenAttemptKey(input.MFAToken)} {
+		if err = app.models.Logins.Reset(key); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	app.issueTokenPair(w, r, usr.ID, nil)
+}
+
+// useTOTPCode validates the code and marks its time step as used, it returns ErrRecordNotFound for a wrong code and
+// ErrEditConflict for a replayed one
+func (app *application) useTOTPCode(userID int64, code string) error {
+	enrollment, err := app.models.MFA.GetTOTP(userID)
+	if err != nil {
+		return err
+	}
+	step, ok := totp.Validate(enrollment.Secret, code, time.Now())
+	if !ok {
+		return data.ErrRecordNotFound
+	}
+	return app.models.MFA.UseStep(userID, step)
+}
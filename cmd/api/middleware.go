@@ -4,11 +4,13 @@ import (
 	"errors"
 	"fmt"
 	"github.com/M0hammadUsman/greenlight/internal/data"
+	"github.com/M0hammadUsman/greenlight/internal/jwt"
 	metric "github.com/M0hammadUsman/greenlight/internal/metrics"
 	"github.com/M0hammadUsman/greenlight/internal/validator"
 	"github.com/felixge/httpsnoop"
 	"github.com/tomasen/realip"
 	"golang.org/x/time/rate"
+	"log/slog"
 	"net/http"
 	"strings"
 	"sync"
@@ -72,6 +74,11 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Authorization") // The response may vary based on Authorization header
+		w.Header().Add("Vary", "X-API-Key")
+		if key := r.Header.Get("X-API-Key"); key != "" {
+			app.authenticateAPIKey(w, r, next, key)
+			return
+		}
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
 			r = app.contextSetUser(r, data.AnonymousUser)
@@ -79,16 +86,32 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 		headerParts := strings.Split(authHeader, " ")
+		if len(headerParts) == 2 && headerParts[0] == "ApiKey" {
+			app.authenticateAPIKey(w, r, next, headerParts[1])
+			return
+		}
 		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
 			app.invalidCredentialResponse(w, r)
 			return
 		}
 		token := headerParts[1]
+		if app.signer != nil && jwt.LooksSigned(token) {
+			claims, err := app.signer.Verify(token)
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+			r = app.contextSetUser(r, &data.User{ID: claims.Subject, Activated: claims.Activated})
+			r = app.contextSetClaims(r, claims)
+			next.ServeHTTP(w, r)
+			return
+		}
 		v := validator.New()
 		if data.ValidateTokenPlainText(v, token); !v.Valid() {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
+		// Revoked tokens are deleted from the tokens table, so this lookup rejects them on the very next request
 		usr, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
@@ -100,6 +123,93 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 		r = app.contextSetUser(r, usr)
+		tokenHash := data.HashTokenPlainText(token)
+		app.lastUsed.touch(tokenHash)
+		r = app.contextSetTokenHash(r, tokenHash)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticateAPIKey is the part of authenticate that handles requests carrying an API key instead of a bearer token
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, keyPlainText string) {
+	v := validator.New()
+	if data.ValidateTokenPlainText(v, keyPlainText); !v.Valid() {
+		app.invalidAPIKeyResponse(w, r)
+		return
+	}
+	key, err := app.models.APIKeys.GetForPlainText(keyPlainText)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAPIKeyResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	usr, err := app.models.Users.Get(key.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	r = app.contextSetUser(r, usr)
+	r = app.contextSetAPIKey(r, key)
+	next.ServeHTTP(w, r)
+}
+
+// auditImpersonation records every request made with an impersonation token, along with the response status. The
+// entry is written before the request is served & the request fails if it can't be, so nothing done while
+// impersonating goes unrecorded. The status is filled in once the response is written.
+func (app *application) auditImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		usr := app.contextGetUser(r)
+		if !usr.IsImpersonated() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		entry := &data.AuditEntry{
+			ActorID: *usr.ImpersonatedBy,
+			UserID:  usr.ID,
+			Action:  data.AuditImpersonatedRequest,
+			Method:  r.Method,
+			Path:    r.URL.Path,
+			IP:      realip.FromRequest(r),
+		}
+		if err := app.models.Audit.Insert(entry); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		metrics := httpsnoop.CaptureMetrics(next, w, r)
+		entry.Status = metrics.Code
+		slog.Info("impersonated request",
+			"admin_id", entry.ActorID, "user_id", entry.UserID,
+			"method", entry.Method, "path", entry.Path, "status", entry.Status,
+		)
+		if err := app.models.Audit.UpdateStatus(entry); err != nil {
+			slog.Error(err.Error())
+		}
+	})
+}
+
+// requireNoAPIKey keeps API keys to the permission-gated catalog, they can't reach account, token or admin routes
+func (app *application) requireNoAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetAPIKey(r) != nil {
+			app.apiKeyNotAllowedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireNotImpersonated guards the self-service actions an admin acting as someone else must not take, such as
+// changing their password or email
+func (app *application) requireNotImpersonated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetUser(r).IsImpersonated() {
+			app.impersonationNotAllowedResponse(w, r)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
@@ -126,15 +236,51 @@ func (app *application) requireActivatedUser(next http.Handler) http.Handler {
 	})
 }
 
+// requireOrganization scopes the request to the organization named by the {org} path segment or, on the unscoped
+// routes, the X-Organization header, falling back to the default organization. Only its members get through.
+func (app *application) requireOrganization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "X-Organization")
+		slug := r.PathValue("org")
+		if slug == "" {
+			slug = r.Header.Get("X-Organization")
+		}
+		if slug == "" {
+			slug = data.DefaultOrganization
+		}
+		org, err := app.models.Organizations.GetBySlug(slug)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		permissions, err := app.models.Organizations.GetMemberPermissions(org.ID, app.contextGetUser(r).ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notPermittedResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		r = app.contextSetOrganization(r, org, permissions)
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		usr := app.contextGetUser(r)
-		permissions, err := app.models.Permissions.GetAllForUser(usr.ID)
+		permitted, err := app.hasPermission(r, code)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
-		if !permissions.Include(code) {
+		if !permitted {
 			app.notPermittedResponse(w, r)
 			return
 		}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"github.com/M0hammadUsman/greenlight/internal/data"
 	"github.com/M0hammadUsman/greenlight/internal/validator"
+	"log/slog"
 	"net/http"
+	"time"
 )
 
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
@@ -19,11 +21,15 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		app.badRequestResponse(w, r, err)
 		return
 	}
+	usr := app.contextGetUser(r)
+	org := app.contextGetOrganization(r)
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Title:          input.Title,
+		Year:           input.Year,
+		Runtime:        input.Runtime,
+		Genres:         input.Genres,
+		CreatedBy:      &usr.ID,
+		OrganizationID: org.ID,
 	}
 	v := validator.New()
 	if data.ValidateMovie(v, movie); !v.Valid() {
@@ -36,7 +42,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/v1/movies/%v", movie.ID))
+	headers.Set("Location", fmt.Sprintf("/v1/orgs/%s/movies/%v", org.Slug, movie.ID))
 	if err = app.writeJSON(w, envelop{"movie": movie}, http.StatusCreated, headers); err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -48,7 +54,7 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		app.notFoundResponse(w, r)
 		return
 	}
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(app.contextGetOrganization(r).ID, id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -58,6 +64,10 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		}
 		return
 	}
+	if movie.Credits, err = app.models.Credits.GetAllForMovie(movie.ID); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 	if err = app.writeJSON(w, envelop{"movie": movie}, http.StatusOK, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -67,8 +77,9 @@ func (app *application) UpdateMovieHandler(w http.ResponseWriter, r *http.Reques
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
+		return
 	}
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(app.contextGetOrganization(r).ID, id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -78,6 +89,15 @@ func (app *application) UpdateMovieHandler(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
+	editor, err := app.movieEditor(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !editor.CanEdit(movie) {
+		app.notPermittedResponse(w, r)
+		return
+	}
 	var input struct {
 		Title   *string       `json:"title"`
 		Year    *int32        `json:"year"`
@@ -107,7 +127,7 @@ func (app *application) UpdateMovieHandler(w http.ResponseWriter, r *http.Reques
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	if err = app.models.Movies.Update(movie); err != nil {
+	if err = app.models.Movies.Update(movie, editor); err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
@@ -125,8 +145,28 @@ func (app *application) DeleteMovieHandler(w http.ResponseWriter, r *http.Reques
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
+		return
+	}
+	movie, err := app.models.Movies.Get(app.contextGetOrganization(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
-	if err = app.models.Movies.Delete(id); err != nil {
+	editor, err := app.movieEditor(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !editor.CanEdit(movie) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+	if err = app.models.Movies.Delete(movie.OrganizationID, id, editor); err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
@@ -135,36 +175,242 @@ func (app *application) DeleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
-	if err = app.writeJSON(w, envelop{"message": "movie successfully deleted"}, http.StatusOK, nil); err != nil {
+	if err = app.writeJSON(w, envelop{"message": "movie successfully moved to trash"}, http.StatusOK, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// replaceMovieCreditsHandler swaps the movie's whole cast & crew for the given list, under the same ownership rule
+// as UpdateMovieHandler
+func (app *application) replaceMovieCreditsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	movie, err := app.models.Movies.Get(app.contextGetOrganization(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	editor, err := app.movieEditor(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !editor.CanEdit(movie) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+	var input struct {
+		Credits []*data.Credit `json:"credits"`
+	}
+	if err = app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.Credits != nil, "credits", "must be provided")
+	if data.ValidateCredits(v, input.Credits); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if err = app.models.Credits.ReplaceForMovie(movie, input.Credits); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("credits", "must only reference people in the movie's organization")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if movie.Credits, err = app.models.Credits.GetAllForMovie(movie.ID); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = app.writeJSON(w, envelop{"movie": movie}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listMoviesHandler pages with page & page_size, or by keyset when a cursor param is present. An empty cursor starts
+// from the first page and the metadata's next_cursor & prev_cursor lead on from there. Keyset pages only count the
+// total when count=true.
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
-		Genres []string
+		Title    string
+		Genres   []string
+		PersonID int
+		Cursor   *data.Cursor
+		Count    bool
 		data.Filters
 	}
 	v := validator.New()
 	qs := r.URL.Query()
 	input.Title = app.readString(qs, "title", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.PersonID = app.readInt(qs, "person", 0, v)
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.SortSafeList = []string{
+		"id", "title", "year", "runtime", "rating", "-id", "-title", "-year", "-runtime", "-rating",
+	}
+	keyset := qs.Has("cursor")
+	if token := qs.Get("cursor"); token != "" {
+		cursor, err := data.DecodeCursor(token, app.cursorKey)
+		if err != nil {
+			v.AddError("cursor", "must be a cursor returned by this endpoint")
+		} else {
+			v.Check(cursor.Sort == input.Filters.Sort, "cursor", "was issued for a different sort")
+			input.Cursor = cursor
+		}
+	}
+	if count := app.readBool(qs, "count", v); count != nil {
+		input.Count = *count
+	}
+	v.Check(input.PersonID >= 0, "person", "must be a valid person id")
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	orgID := app.contextGetOrganization(r).ID
+	if !keyset {
+		movies, metadata, err := app.models.Movies.GetAll(orgID, input.Title, input.Genres, int64(input.PersonID), input.Filters)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if err = app.writeJSON(w, envelop{"movies": movies, "metadata": metadata}, http.StatusOK, nil); err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	movies, page, err := app.models.Movies.GetAllAfter(
+		orgID, input.Title, input.Genres, int64(input.PersonID), input.Filters, input.Cursor, input.Count,
+	)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	metadata := data.Metadata{PageSize: input.Filters.PageSize, TotalRecords: page.TotalRecords}
+	if page.Next != nil {
+		metadata.NextCursor = page.Next.Encode(app.cursorKey)
+	}
+	if page.Prev != nil {
+		metadata.PrevCursor = page.Prev.Encode(app.cursorKey)
+	}
 	if err = app.writeJSON(w, envelop{"movies": movies, "metadata": metadata}, http.StatusOK, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
+}
+
+func (app *application) listTrashedMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-deleted_at")
+	input.Filters.SortSafeList = []string{"id", "title", "deleted_at", "-id", "-title", "-deleted_at"}
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	movies, metadata, err := app.models.Movies.GetAllDeleted(app.contextGetOrganization(r).ID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = app.writeJSON(w, envelop{"movies": movies, "metadata": metadata}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readTrashedMovieParam is readMovieParam for movies in the trash
+func (app *application) readTrashedMovieParam(w http.ResponseWriter, r *http.Request) (*data.Movie, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	movie, err := app.models.Movies.GetDeleted(app.contextGetOrganization(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return movie, true
+}
+
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readTrashedMovieParam(w, r)
+	if !ok {
+		return
+	}
+	editor, err := app.movieEditor(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !editor.CanEdit(movie) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+	if err = app.models.Movies.Restore(movie, editor); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err = app.writeJSON(w, envelop{"movie": movie}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
 
+// purgeMovieHandler permanently deletes a movie, only ones already in the trash can be purged
+func (app *application) purgeMovieHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readTrashedMovieParam(w, r)
+	if !ok {
+		return
+	}
+	if err := app.models.Movies.Purge(movie.OrganizationID, movie.ID); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err := app.writeJSON(w, envelop{"message": "movie permanently deleted"}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// purgeTrashedMovies permanently deletes movies that have been in the trash longer than the retention period
+func (app *application) purgeTrashedMovies() {
+	n, err := app.models.Movies.PurgeDeleted(time.Now().Add(-app.config.movies.trashRetention))
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+	if n > 0 {
+		slog.Info("purged trashed movies", "count", n)
+	}
 }
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/M0hammadUsman/greenlight/internal/data"
+	"github.com/M0hammadUsman/greenlight/internal/validator"
+	"net/http"
+)
+
+// ownerPermissions are what the creator of an organization holds within it
+var ownerPermissions = []string{"orgs:manage", "movies:*"}
+
+func (app *application) createOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+		Slug string `json:"slug"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	org := &data.Organization{Name: input.Name, Slug: input.Slug}
+	v := validator.New()
+	if data.ValidateOrganization(v, org); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	usr := app.contextGetUser(r)
+	if err := app.models.Organizations.Insert(org, usr.ID, ownerPermissions...); err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "an organization with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/orgs/%s/movies", org.Slug))
+	if err := app.writeJSON(w, envelop{"organization": org}, http.StatusCreated, headers); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listCurrentUserOrganizationsHandler(w http.ResponseWriter, r *http.Request) {
+	usr := app.contextGetUser(r)
+	orgs, err := app.models.Organizations.GetAllForUser(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = app.writeJSON(w, envelop{"organizations": orgs}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listMembersHandler(w http.ResponseWriter, r *http.Request) {
+	members, err := app.models.Organizations.GetAllMembers(app.contextGetOrganization(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = app.writeJSON(w, envelop{"members": members}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// validateMemberPermissions checks the permissions a member is given within the organization, writing the
+// error response itself on failure
+func (app *application) validateMemberPermissions(w http.ResponseWriter, r *http.Request, v *validator.Validator, permissions []string) bool {
+	codes, err := app.models.Permissions.GetAllCodes()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	v.Check(validator.Unique(permissions), "permissions", "must not contain duplicate values")
+	for _, code := range permissions {
+		v.Check(validator.In(code, codes...), "permissions", "must only contain known permission codes")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+	return true
+}
+
+// addMemberHandler adds an existing user, named by their email address, to the organization. Users are never
+// looked up by id here, so managers can't walk the user table to learn other accounts' addresses.
+func (app *application) addMemberHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email       string   `json:"email"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if !app.validateMemberPermissions(w, r, v, input.Permissions) {
+		return
+	}
+	usr, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	org := app.contextGetOrganization(r)
+	if err = app.models.Organizations.AddMember(org.ID, usr.ID, input.Permissions...); err != nil {
+		switch {
+		case errors.Is(err, data.ErrAlreadyMember):
+			v.AddError("email", "this user is already a member, update their permissions instead")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	member := &data.Member{UserID: usr.ID, Email: input.Email, Permissions: input.Permissions}
+	if member.Permissions == nil {
+		member.Permissions = data.Permissions{}
+	}
+	if err = app.writeJSON(w, envelop{"member": member}, http.StatusCreated, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateMemberHandler replaces the permissions a member holds within the organization
+func (app *application) updateMemberHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		Permissions []string `json:"permissions"`
+	}
+	if err = app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if !app.validateMemberPermissions(w, r, validator.New(), input.Permissions) {
+		return
+	}
+	org := app.contextGetOrganization(r)
+	if err = app.models.Organizations.UpdateMember(org.ID, id, input.Permissions...); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	member := &data.Member{UserID: id, Permissions: input.Permissions}
+	if member.Permissions == nil {
+		member.Permissions = data.Permissions{}
+	}
+	if err = app.writeJSON(w, envelop{"member": member}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteMemberHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	if err = app.models.Organizations.RemoveMember(app.contextGetOrganization(r).ID, id); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err = app.writeJSON(w, envelop{"message": "member successfully removed"}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
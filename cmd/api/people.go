@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/M0hammadUsman/greenlight/internal/data"
+	"github.com/M0hammadUsman/greenlight/internal/validator"
+	"net/http"
+)
+
+func (app *application) createPersonHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name      string `json:"name"`
+		BirthYear *int32 `json:"birth_year"`
+		Bio       string `json:"bio"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	org := app.contextGetOrganization(r)
+	person := &data.Person{
+		Name:           input.Name,
+		BirthYear:      input.BirthYear,
+		Bio:            input.Bio,
+		OrganizationID: org.ID,
+	}
+	v := validator.New()
+	if data.ValidatePerson(v, person); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if err := app.models.People.Insert(person); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/orgs/%s/people/%v", org.Slug, person.ID))
+	if err := app.writeJSON(w, envelop{"person": person}, http.StatusCreated, headers); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readPersonParam loads the person named by the id path parameter, writing the error response itself on failure
+func (app *application) readPersonParam(w http.ResponseWriter, r *http.Request) (*data.Person, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	person, err := app.models.People.Get(app.contextGetOrganization(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return person, true
+}
+
+func (app *application) showPersonHandler(w http.ResponseWriter, r *http.Request) {
+	person, ok := app.readPersonParam(w, r)
+	if !ok {
+		return
+	}
+	if err := app.writeJSON(w, envelop{"person": person}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// personEditor checks the ownership rule for people & returns the editor to pass on to the model, writing the error
+// response itself when the rule isn't met
+func (app *application) personEditor(w http.ResponseWriter, r *http.Request, person *data.Person) (data.MovieEditor, bool) {
+	editor, err := app.movieEditor(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return data.MovieEditor{}, false
+	}
+	ok, err := app.models.People.CanEdit(person, editor)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return data.MovieEditor{}, false
+	}
+	if !ok {
+		app.notPermittedResponse(w, r)
+		return data.MovieEditor{}, false
+	}
+	return editor, true
+}
+
+func (app *application) updatePersonHandler(w http.ResponseWriter, r *http.Request) {
+	person, ok := app.readPersonParam(w, r)
+	if !ok {
+		return
+	}
+	editor, ok := app.personEditor(w, r, person)
+	if !ok {
+		return
+	}
+	var input struct {
+		Name      *string `json:"name"`
+		BirthYear *int32  `json:"birth_year"`
+		Bio       *string `json:"bio"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Name != nil {
+		person.Name = *input.Name
+	}
+	if input.BirthYear != nil {
+		person.BirthYear = input.BirthYear
+	}
+	if input.Bio != nil {
+		person.Bio = *input.Bio
+	}
+	v := validator.New()
+	if data.ValidatePerson(v, person); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if err := app.models.People.Update(person, editor); err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err := app.writeJSON(w, envelop{"person": person}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deletePersonHandler(w http.ResponseWriter, r *http.Request) {
+	person, ok := app.readPersonParam(w, r)
+	if !ok {
+		return
+	}
+	editor, ok := app.personEditor(w, r, person)
+	if !ok {
+		return
+	}
+	if err := app.models.People.Delete(person.OrganizationID, person.ID, editor); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err := app.writeJSON(w, envelop{"message": "person successfully deleted"}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listPeopleHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	input.Name = app.readString(qs, "name", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafeList = []string{"id", "name", "birth_year", "-id", "-name", "-birth_year"}
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	people, metadata, err := app.models.People.GetAll(app.contextGetOrganization(r).ID, input.Name, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = app.writeJSON(w, envelop{"people": people, "metadata": metadata}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
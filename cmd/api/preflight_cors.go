@@ -12,7 +12,7 @@ func (app *application) preflightCORSHandler(w http.ResponseWriter, r *http.Requ
 			if origin == app.config.cors.trustedOrigins[i] {
 				if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 					w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-					w.Header().Set("Access-Control-Allow-Headers", "authorization, content-type")
+					w.Header().Set("Access-Control-Allow-Headers", "authorization, content-type, x-api-key")
 					w.WriteHeader(http.StatusOK)
 				}
 			}
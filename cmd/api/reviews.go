@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"github.com/M0hammadUsman/greenlight/internal/data"
+	"github.com/M0hammadUsman/greenlight/internal/validator"
+	"net/http"
+)
+
+// readMovieParam loads the movie named by the id path parameter from the request's organization, writing the error
+// response itself on failure
+func (app *application) readMovieParam(w http.ResponseWriter, r *http.Request) (*data.Movie, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	movie, err := app.models.Movies.Get(app.contextGetOrganization(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return movie, true
+}
+
+func (app *application) createReviewHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovieParam(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		Score int32  `json:"score"`
+		Body  string `json:"body"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	review := &data.Review{
+		MovieID: movie.ID,
+		UserID:  app.contextGetUser(r).ID,
+		Score:   input.Score,
+		Body:    input.Body,
+	}
+	v := validator.New()
+	if data.ValidateReview(v, review); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if err := app.models.Reviews.Insert(review); err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateReview):
+			v.AddError("review", "you have already reviewed this movie, update your review instead")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err := app.writeJSON(w, envelop{"review": review}, http.StatusCreated, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateReviewHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovieParam(w, r)
+	if !ok {
+		return
+	}
+	review, err := app.models.Reviews.GetForUser(movie.ID, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	var input struct {
+		Score   *int32  `json:"score"`
+		Body    *string `json:"body"`
+		Version *int32  `json:"version"`
+	}
+	if err = app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	// The client may pin the version it last saw, otherwise the one just read is used
+	if input.Version != nil && *input.Version != review.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+	if input.Score != nil {
+		review.Score = *input.Score
+	}
+	if input.Body != nil {
+		review.Body = *input.Body
+	}
+	v := validator.New()
+	if data.ValidateReview(v, review); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if err = app.models.Reviews.Update(review); err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err = app.writeJSON(w, envelop{"review": review}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteReviewHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovieParam(w, r)
+	if !ok {
+		return
+	}
+	if err := app.models.Reviews.Delete(movie.ID, app.contextGetUser(r).ID); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err := app.writeJSON(w, envelop{"message": "review successfully deleted"}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovieParam(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafeList = []string{"id", "created_at", "score", "-id", "-created_at", "-score"}
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	reviews, metadata, err := app.models.Reviews.GetAllForMovie(movie.ID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = app.writeJSON(w, envelop{"reviews": reviews, "metadata": metadata}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
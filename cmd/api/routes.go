@@ -6,8 +6,17 @@ import (
 )
 
 func (app *application) routes() http.Handler {
-	base := alice.New(app.metrics, app.recoverPanic, app.enableCORS, app.rateLimit, app.authenticate)
-	protected := alice.New(app.requireAuthenticatedUser, app.requireActivatedUser)
+	base := alice.New(app.metrics, app.recoverPanic, app.enableCORS, app.rateLimit, app.authenticate, app.auditImpersonation)
+	// API keys are scoped to catalog permissions, so only the catalog chain lets them through. Otherwise a read-only
+	// key could change the account's email, mint a never expiring key, etc.
+	noAPIKey := alice.New(app.requireNoAPIKey)
+	authenticated := noAPIKey.Append(app.requireAuthenticatedUser)
+	protected := authenticated.Append(app.requireActivatedUser)
+	// Sensitive self-service actions are off limits to admins impersonating the user
+	sensitive := authenticated.Append(app.requireNotImpersonated)
+	protectedSensitive := protected.Append(app.requireNotImpersonated)
+	scoped := protected.Append(app.requireOrganization)
+	catalog := alice.New(app.requireAuthenticatedUser, app.requireActivatedUser, app.requireOrganization)
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("OPTIONS /", app.preflightCORSHandler)
@@ -15,17 +24,85 @@ func (app *application) routes() http.Handler {
 	mux.HandleFunc("GET /v1/healthcheck", app.healthcheckHandler)
 	mux.HandleFunc("GET /debug/vars", app.customVarHandler)
 
-	mux.Handle("GET /v1/movies", protected.Then(app.requirePermission("movies:read", app.listMoviesHandler)))
-	mux.Handle("GET /v1/movies/{id}", protected.Then(app.requirePermission("movies:read", app.showMovieHandler)))
-	mux.Handle("POST /v1/movies", protected.Then(app.requirePermission("movies:write", app.createMovieHandler)))
-	mux.Handle("PATCH /v1/movies/{id}", protected.Then(app.requirePermission("movies:write", app.UpdateMovieHandler)))
-	mux.Handle("DELETE /v1/movies/{id}", protected.Then(app.requirePermission("movies:write", app.DeleteMovieHandler)))
+	// Catalog routes are scoped to an organization, named by the {org} segment or else the X-Organization header
+	for _, prefix := range []string{"/v1", "/v1/orgs/{org}"} {
+		mux.Handle("GET "+prefix+"/movies", catalog.Then(app.requirePermission("movies:read", app.listMoviesHandler)))
+		mux.Handle("GET "+prefix+"/movies/{id}", catalog.Then(app.requirePermission("movies:read", app.showMovieHandler)))
+		mux.Handle("POST "+prefix+"/movies", catalog.Then(app.requirePermission("movies:write", app.createMovieHandler)))
+		mux.Handle("PATCH "+prefix+"/movies/{id}", catalog.Then(app.requirePermission("movies:write", app.UpdateMovieHandler)))
+		mux.Handle("DELETE "+prefix+"/movies/{id}", catalog.Then(app.requirePermission("movies:write", app.DeleteMovieHandler)))
+		mux.Handle("GET "+prefix+"/movies/trash", catalog.Then(app.requirePermission("movies:write", app.listTrashedMoviesHandler)))
+		mux.Handle("POST "+prefix+"/movies/{id}/restore", catalog.Then(app.requirePermission("movies:write", app.restoreMovieHandler)))
+		mux.Handle("DELETE "+prefix+"/movies/{id}/purge", catalog.Then(app.requirePermission("movies:purge", app.purgeMovieHandler)))
+		mux.Handle("GET "+prefix+"/movies/{id}/reviews", catalog.Then(app.requirePermission("movies:read", app.listReviewsHandler)))
+		mux.Handle("POST "+prefix+"/movies/{id}/reviews", catalog.Then(app.requirePermission("movies:read", app.createReviewHandler)))
+		mux.Handle("PUT "+prefix+"/movies/{id}/reviews", catalog.Then(app.requirePermission("movies:read", app.updateReviewHandler)))
+		mux.Handle("DELETE "+prefix+"/movies/{id}/reviews", catalog.Then(app.requirePermission("movies:read", app.deleteReviewHandler)))
+		mux.Handle("PUT "+prefix+"/movies/{id}/credits", catalog.Then(app.requirePermission("movies:write", app.replaceMovieCreditsHandler)))
+		mux.Handle("GET "+prefix+"/people", catalog.Then(app.requirePermission("movies:read", app.listPeopleHandler)))
+		mux.Handle("GET "+prefix+"/people/{id}", catalog.Then(app.requirePermission("movies:read", app.showPersonHandler)))
+		mux.Handle("POST "+prefix+"/people", catalog.Then(app.requirePermission("movies:write", app.createPersonHandler)))
+		mux.Handle("PATCH "+prefix+"/people/{id}", catalog.Then(app.requirePermission("movies:write", app.updatePersonHandler)))
+		mux.Handle("DELETE "+prefix+"/people/{id}", catalog.Then(app.requirePermission("movies:write", app.deletePersonHandler)))
+	}
 
-	mux.HandleFunc("POST /v1/users", app.registerUserHandler)
-	mux.HandleFunc("PUT /v1/users/activated", app.activateUserHandler)
+	mux.Handle("POST /v1/orgs", protected.Then(app.requirePermission("users:admin", app.createOrganizationHandler)))
+	mux.Handle("GET /v1/orgs/{org}/members", scoped.Then(app.requirePermission("orgs:manage", app.listMembersHandler)))
+	mux.Handle("POST /v1/orgs/{org}/members", scoped.Then(app.requirePermission("orgs:manage", app.addMemberHandler)))
+	mux.Handle("PUT /v1/orgs/{org}/members/{id}", scoped.Then(app.requirePermission("orgs:manage", app.updateMemberHandler)))
+	mux.Handle("DELETE /v1/orgs/{org}/members/{id}", scoped.Then(app.requirePermission("orgs:manage", app.deleteMemberHandler)))
 
-	mux.HandleFunc("POST /v1/tokens/activation", app.createActivationTokenHandler)
-	mux.HandleFunc("POST /v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	mux.Handle("POST /v1/users", noAPIKey.ThenFunc(app.registerUserHandler))
+	mux.Handle("PUT /v1/users/activated", noAPIKey.ThenFunc(app.activateUserHandler))
+	mux.Handle("PUT /v1/users/password", noAPIKey.ThenFunc(app.updateUserPasswordHandler))
+	mux.Handle("GET /v1/users/me", authenticated.ThenFunc(app.showCurrentUserHandler))
+	mux.Handle("PATCH /v1/users/me", protected.ThenFunc(app.updateCurrentUserHandler))
+	mux.Handle("DELETE /v1/users/me", sensitive.ThenFunc(app.deleteCurrentUserHandler))
+	mux.Handle("GET /v1/users/me/export", sensitive.ThenFunc(app.exportCurrentUserHandler))
+	mux.Handle("PUT /v1/users/me/password", protectedSensitive.ThenFunc(app.changeCurrentUserPasswordHandler))
+	mux.Handle("POST /v1/users/me/email", protectedSensitive.ThenFunc(app.requestEmailChangeHandler))
+	mux.Handle("PUT /v1/users/me/email", protectedSensitive.ThenFunc(app.confirmEmailChangeHandler))
+	mux.Handle("GET /v1/users/me/sessions", authenticated.ThenFunc(app.listSessionsHandler))
+	mux.Handle("DELETE /v1/users/me/sessions/{id}", sensitive.ThenFunc(app.deleteSessionHandler))
+	mux.Handle("POST /v1/users/me/mfa/totp", protectedSensitive.ThenFunc(app.enrollTOTPHandler))
+	mux.Handle("PUT /v1/users/me/mfa/totp", protectedSensitive.ThenFunc(app.confirmTOTPHandler))
+	mux.Handle("DELETE /v1/users/me/mfa/totp", protectedSensitive.ThenFunc(app.disableTOTPHandler))
+	mux.Handle("POST /v1/users/me/mfa/recovery-codes", protectedSensitive.ThenFunc(app.regenerateRecoveryCodesHandler))
+	mux.Handle("GET /v1/users/me/orgs", authenticated.ThenFunc(app.listCurrentUserOrganizationsHandler))
+	mux.Handle("GET /v1/users/me/lists", protected.ThenFunc(app.listCurrentUserListsHandler))
+	mux.Handle("POST /v1/users/me/lists", protected.ThenFunc(app.createListHandler))
+	mux.Handle("GET /v1/users/me/lists/{id}", protected.ThenFunc(app.showListHandler))
+	mux.Handle("PATCH /v1/users/me/lists/{id}", protected.ThenFunc(app.updateListHandler))
+	mux.Handle("DELETE /v1/users/me/lists/{id}", protected.ThenFunc(app.deleteListHandler))
+	// Movies are added from the organization named by the X-Organization header, like the rest of the catalog
+	mux.Handle("POST /v1/users/me/lists/{id}/items", scoped.Then(app.requirePermission("movies:read", app.addListItemHandler)))
+	mux.Handle("DELETE /v1/users/me/lists/{id}/items/{movie_id}", protected.ThenFunc(app.removeListItemHandler))
+	mux.Handle("PUT /v1/users/me/lists/{id}/items/order", protected.ThenFunc(app.reorderListItemsHandler))
+	mux.HandleFunc("GET /v1/lists/{id}", app.showPublicListHandler)
+	mux.Handle("GET /v1/users/me/api-keys", protected.ThenFunc(app.listAPIKeysHandler))
+	mux.Handle("POST /v1/users/me/api-keys", protectedSensitive.ThenFunc(app.createAPIKeyHandler))
+	mux.Handle("DELETE /v1/users/me/api-keys/{id}", protectedSensitive.ThenFunc(app.deleteAPIKeyHandler))
+
+	mux.Handle("GET /v1/admin/users", protected.Then(app.requirePermission("users:admin", app.listUsersHandler)))
+	mux.Handle("PATCH /v1/admin/users/{id}", protected.Then(app.requirePermission("users:admin", app.updateUserHandler)))
+	mux.Handle("GET /v1/admin/users/{id}/permissions", protected.Then(app.requirePermission("users:admin", app.showUserPermissionsHandler)))
+	mux.Handle("PUT /v1/admin/users/{id}/permissions", protected.Then(app.requirePermission("users:admin", app.replaceUserPermissionsHandler)))
+	mux.Handle("POST /v1/admin/users/{id}/impersonate", protectedSensitive.Then(app.requirePermission("users:admin", app.impersonateUserHandler)))
+	mux.Handle("DELETE /v1/admin/users/{id}/permissions", protected.Then(app.requirePermission("users:admin", app.deleteUserPermissionsHandler)))
+
+	mux.Handle("GET /v1/admin/invitations", protected.Then(app.requirePermission("users:admin", app.listInvitationsHandler)))
+	mux.Handle("POST /v1/admin/invitations", protected.Then(app.requirePermission("users:admin", app.createInvitationHandler)))
+	mux.Handle("DELETE /v1/admin/invitations/{id}", protected.Then(app.requirePermission("users:admin", app.deleteInvitationHandler)))
+
+	mux.Handle("POST /v1/tokens/activation", noAPIKey.ThenFunc(app.createActivationTokenHandler))
+	mux.Handle("POST /v1/tokens/authentication", noAPIKey.ThenFunc(app.createAuthenticationTokenHandler))
+	mux.Handle("POST /v1/tokens/mfa", noAPIKey.ThenFunc(app.createMFAAuthenticationTokenHandler))
+	mux.Handle("POST /v1/tokens/magic-link", noAPIKey.ThenFunc(app.createMagicLinkTokenHandler))
+	mux.Handle("POST /v1/tokens/magic-link/redeem", noAPIKey.ThenFunc(app.redeemMagicLinkTokenHandler))
+	mux.Handle("POST /v1/tokens/refresh", noAPIKey.ThenFunc(app.refreshAuthenticationTokenHandler))
+	mux.Handle("POST /v1/tokens/password-reset", noAPIKey.ThenFunc(app.createPasswordResetTokenHandler))
+	mux.Handle("DELETE /v1/tokens/authentication", authenticated.ThenFunc(app.deleteAuthenticationTokenHandler))
+	mux.Handle("DELETE /v1/tokens/authentication/all", sensitive.ThenFunc(app.deleteAllAuthenticationTokensHandler))
 
 	return base.Then(mux)
 }
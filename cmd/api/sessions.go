@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"github.com/M0hammadUsman/greenlight/internal/data"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// lastUsedTracker buffers the last time each authentication token was seen, so authenticate doesn't have to write
+// to the database on every request. The buffered timestamps are flushed in a batch by flushLastUsed.
+type lastUsedTracker struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newLastUsedTracker() *lastUsedTracker {
+	return &lastUsedTracker{seen: make(map[string]time.Time)}
+}
+
+func (t *lastUsedTracker) touch(hash []byte) {
+	t.mu.Lock()
+	t.seen[string(hash)] = time.Now()
+	t.mu.Unlock()
+}
+
+func (t *lastUsedTracker) get(hash []byte) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lastUsed, ok := t.seen[string(hash)]
+	return lastUsed, ok
+}
+
+// drain hands over the buffered timestamps and starts a new buffer
+func (t *lastUsedTracker) drain() map[string]time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	seen := t.seen
+	t.seen = make(map[string]time.Time)
+	return seen
+}
+
+func (app *application) flushLastUsed() {
+	if err := app.models.Tokens.UpdateLastUsed(app.lastUsed.drain()); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	usr := app.contextGetUser(r)
+	sessions, err := app.models.Tokens.GetAllSessionsForUser(usr.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	currentHash := string(app.contextGetTokenHash(r))
+	for _, s := range sessions {
+		// The database may lag behind by up to one flush interval
+		if lastUsed, ok := app.lastUsed.get(s.Hash); ok {
+			s.LastUsedAt = &lastUsed
+		}
+		s.Current = string(s.Hash) == currentHash
+	}
+	if err = app.writeJSON(w, envelop{"sessions": sessions}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	usr := app.contextGetUser(r)
+	if err = app.models.Tokens.DeleteSessionForUser(id, usr.ID); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err = app.writeJSON(w, envelop{"message": "session successfully revoked"}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
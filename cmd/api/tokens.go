@@ -1,9 +1,12 @@
 package main
 
 import (
+	"encoding/base64"
 	"errors"
 	"github.com/M0hammadUsman/greenlight/internal/data"
+	"github.com/M0hammadUsman/greenlight/internal/jwt"
 	"github.com/M0hammadUsman/greenlight/internal/validator"
+	"github.com/tomasen/realip"
 	"log/slog"
 	"net/http"
 	"time"
@@ -25,10 +28,18 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+	if app.loginLocked(w, r, input.Email) {
+		return
+	}
 	usr, err := app.models.Users.GetByEmail(input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			// Unknown emails count as failures too, so they behave exactly like known ones
+			if err = app.recordLoginFailure(r, input.Email, nil); err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
 			app.invalidCredentialResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -41,19 +52,140 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 	if !match {
+		if err = app.recordLoginFailure(r, input.Email, usr); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 		app.invalidCredentialResponse(w, r)
 		return
 	}
-	token, err := app.models.Tokens.New(usr.ID, 24*time.Hour, data.ScopeAuthentication)
+	if err = app.models.Logins.Reset(data.AccountAttemptKey(input.Email)); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// This is the only time the plain text is at hand, so it's when legacy & outdated hashes get upgraded
+	if usr.Password.NeedsRehash(app.models.Users.PasswordParams) {
+		if err = app.models.Users.RehashPassword(usr, input.Password); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	app.completeLogin(w, r, usr)
+}
+
+// completeLogin runs once the user has proven who they are, by password or magic link. Users with two-factor
+// authentication get a short-lived token to be exchanged at POST /v1/tokens/mfa, everyone else a token pair.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, usr *data.User) {
+	if err := app.cancelScheduledDeletion(usr); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	enrollment, err := app.models.MFA.GetTOTP(usr.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if enrollment != nil && enrollment.Confirmed {
+		token, err := app.models.Tokens.New(usr.ID, 5*time.Minute, data.ScopeMFAPending)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env := envelop{"mfa_token": token, "message": "a two-factor authentication code is required"}
+		if err = app.writeJSON(w, env, http.StatusAccepted, nil); err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.issueTokenPair(w, r, usr.ID, nil)
+}
+
+// issueTokenPair responds with a new access & refresh token pair, family is nil unless a refresh token is rotated
+func (app *application) issueTokenPair(w http.ResponseWriter, r *http.Request, userID int64, family []byte) {
+	if app.signer != nil {
+		app.issueSignedTokenPair(w, r, userID, family)
+		return
+	}
+	ip, userAgent := realip.FromRequest(r), r.UserAgent()
+	ttl, refreshTTL := app.config.auth.accessTokenTTL, app.config.auth.refreshTokenTTL
+	pair, err := app.models.Tokens.NewPair(userID, ttl, refreshTTL, family, ip, userAgent)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	if err = app.writeJSON(w, envelop{"authentication_token": token}, http.StatusCreated, nil); err != nil {
+	env := envelop{"authentication_token": pair.Access, "refresh_token": pair.Refresh}
+	if err = app.writeJSON(w, env, http.StatusCreated, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// issueSignedTokenPair is issueTokenPair for the signed mode, only the refresh token is stored in the database
+func (app *application) issueSignedTokenPair(w http.ResponseWriter, r *http.Request, userID int64, family []byte) {
+	usr, err := app.models.Users.Get(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	permissions, err := app.models.Permissions.GetAllForUser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	ip, userAgent := realip.FromRequest(r), r.UserAgent()
+	refresh, err := app.models.Tokens.NewForFamily(userID, app.config.auth.refreshTokenTTL, data.ScopeRefresh, family, ip, userAgent)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	now := time.Now()
+	access := &data.Token{Expiry: now.Add(app.config.auth.accessTokenTTL)}
+	access.PlainText, err = app.signer.Sign(jwt.Claims{
+		Subject:     usr.ID,
+		Activated:   usr.Activated,
+		Permissions: permissions,
+		IssuedAt:    now.Unix(),
+		Expiry:      access.Expiry.Unix(),
+		Family:      base64.RawURLEncoding.EncodeToString(refresh.Family),
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	env := envelop{"authentication_token": access, "refresh_token": refresh}
+	if err = app.writeJSON(w, env, http.StatusCreated, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateTokenPlainText(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	userID, family, err := app.models.Tokens.Rotate(input.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTokenReused):
+			slog.Warn("refresh token reused, token family revoked", "ip", realip.FromRequest(r))
+			app.invalidAuthenticationTokenResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.issueTokenPair(w, r, userID, family)
+}
+
 func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Email string `json:"email"`
@@ -99,3 +231,181 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	usr, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !usr.Activated {
+		v.AddError("email", "user account must be activated")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	token, err := app.models.Tokens.New(usr.ID, 45*time.Minute, data.ScopePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.runInBackground(func() {
+		d := map[string]any{"passwordResetToken": token.PlainText}
+		if err := app.mailer.Send(usr.Email, "token_password_reset.tmpl.html", d); err != nil {
+			slog.Error(err.Error())
+		}
+	})
+	env := envelop{"message": "an email will be sent to you containing password reset instructions"}
+	if err = app.writeJSON(w, env, http.StatusAccepted, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAuthenticationTokenHandler logs the session out. A signed access token can't be revoked itself, so its refresh
+// family is, and the access token stops working when it expires at the latest.
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if claims := app.contextGetClaims(r); claims != nil {
+		app.deleteSignedSession(w, r, claims)
+		return
+	}
+	if err := app.models.Tokens.DeleteByHash(data.ScopeAuthentication, app.contextGetTokenHash(r)); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	env := envelop{"message": "authentication token successfully revoked"}
+	if err := app.writeJSON(w, env, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteSignedSession(w http.ResponseWriter, r *http.Request, claims *jwt.Claims) {
+	family, err := base64.RawURLEncoding.DecodeString(claims.Family)
+	if err != nil || len(family) == 0 {
+		app.badRequestResponse(w, r, errors.New("this access token has no session to revoke, it expires on its own"))
+		return
+	}
+	if err = app.models.Tokens.DeleteFamily(claims.Subject, family); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	env := envelop{"message": "session successfully revoked, the access token expires on its own"}
+	if err = app.writeJSON(w, env, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	usr := app.contextGetUser(r)
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+		if err := app.models.Tokens.DeleteAllForUser(scope, usr.ID); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	env := envelop{"message": "all authentication tokens successfully revoked"}
+	if err := app.writeJSON(w, env, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Whether or not the email belongs to an account, the response is the same so accounts can't be enumerated
+	env := envelop{"message": "if an account exists for this email, a login link will be sent to it"}
+	usr, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			if err = app.writeJSON(w, env, http.StatusAccepted, nil); err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	token, err := app.models.Tokens.New(usr.ID, 15*time.Minute, data.ScopeMagicLink)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.runInBackground(func() {
+		d := map[string]any{"magicLinkToken": token.PlainText}
+		if err := app.mailer.Send(usr.Email, "token_magic_link.tmpl.html", d); err != nil {
+			slog.Error(err.Error())
+		}
+	})
+	if err = app.writeJSON(w, env, http.StatusAccepted, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) redeemMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlainText string `json:"token"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateTokenPlainText(v, input.TokenPlainText); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	usr, err := app.models.Users.GetForToken(data.ScopeMagicLink, input.TokenPlainText)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired login token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// One-time use, so every outstanding link goes at once
+	if err = app.models.Tokens.DeleteAllForUser(data.ScopeMagicLink, usr.ID); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.completeLogin(w, r, usr)
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/M0hammadUsman/greenlight/internal/validator"
 	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -14,6 +15,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		Name     string `json:"name"`
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		Token    string `json:"token"` // Invitation token, required when registration is invite-only
 	}
 	if err := app.readJSON(w, r, &input); err != nil {
 		app.badRequestResponse(w, r, err)
@@ -24,12 +26,21 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		Email:     input.Email,
 		Activated: false,
 	}
-	if err := user.Password.Set(input.Password); err != nil {
+	if err := user.Password.Set(input.Password, app.models.Users.PasswordParams); err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	if input.Token != "" || app.config.accounts.inviteOnly {
+		app.registerInvitedUser(w, r, user, input.Password, input.Token)
+		return
+	}
 	v := validator.New()
-	if data.ValidateUser(v, user); !v.Valid() {
+	data.ValidateUser(v, user)
+	if err := data.ValidatePasswordPolicy(v, app.models.Users.PasswordPolicy, input.Password, user); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
@@ -43,7 +54,11 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		}
 		return
 	}
-	if err := app.models.Permissions.AddForUser(user.ID, "movies:read"); err != nil {
+	if err := app.models.Roles.AddForUser(user.ID, data.RoleViewer); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err := app.models.Organizations.AddMemberBySlug(data.DefaultOrganization, user.ID); err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
@@ -108,3 +123,290 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlainText string `json:"token"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	data.ValidatePasswordPlainText(v, input.Password)
+	data.ValidateTokenPlainText(v, input.TokenPlainText)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	usr, err := app.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlainText)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err = data.ValidatePasswordPolicy(v, app.models.Users.PasswordPolicy, input.Password, usr); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if err = usr.Password.Set(input.Password, app.models.Users.PasswordParams); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = app.models.Users.UpdateUser(usr); err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Every outstanding reset token and every existing session is invalidated once the password changes
+	for _, scope := range []string{data.ScopePasswordReset, data.ScopeAuthentication, data.ScopeRefresh} {
+		if err = app.models.Tokens.DeleteAllForUser(scope, usr.ID); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	env := envelop{"message": "your password was successfully reset"}
+	if err = app.writeJSON(w, env, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	usr, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err := app.writeJSON(w, envelop{"user": usr}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	usr, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	var input struct {
+		Name *string `json:"name"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Name != nil {
+		usr.Name = *input.Name
+	}
+	v := validator.New()
+	if data.ValidateUser(v, usr); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if err := app.models.Users.UpdateUser(usr); err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err := app.writeJSON(w, envelop{"user": usr}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) changeCurrentUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	usr, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.CurrentPassword != "", "current_password", "must be provided")
+	data.ValidatePasswordPlainText(v, input.NewPassword)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	match, err := usr.Password.Matches(input.CurrentPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		v.AddError("current_password", "does not match your current password")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if err = data.ValidatePasswordPolicy(v, app.models.Users.PasswordPolicy, input.NewPassword, usr); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if err = usr.Password.Set(input.NewPassword, app.models.Users.PasswordParams); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = app.models.Users.UpdateUser(usr); err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Any reset token issued for the old password is no longer useful
+	if err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, usr.ID); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	env := envelop{"message": "your password was successfully changed"}
+	if err = app.writeJSON(w, env, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) requestEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	usr, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	var input struct {
+		Email string `json:"email"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	data.ValidateEmail(v, input.Email)
+	v.Check(!strings.EqualFold(input.Email, usr.Email), "email", "must be different from your current email")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if _, err := app.models.Users.GetByEmail(input.Email); err == nil {
+		v.AddError("email", "a user with this email address already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	} else if !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	oldEmail := usr.Email
+	usr.PendingEmail = &input.Email
+	if err := app.models.Users.UpdateUser(usr); err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Only the latest requested address can be confirmed
+	if err := app.models.Tokens.DeleteAllForUser(data.ScopeEmailChange, usr.ID); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	token, err := app.models.Tokens.New(usr.ID, 24*time.Hour, data.ScopeEmailChange)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.runInBackground(func() {
+		d := map[string]any{"emailChangeToken": token.PlainText}
+		if err := app.mailer.Send(input.Email, "token_email_change.tmpl.html", d); err != nil {
+			slog.Error(err.Error())
+		}
+		d = map[string]any{"newEmail": input.Email}
+		if err := app.mailer.Send(oldEmail, "email_change_notice.tmpl.html", d); err != nil {
+			slog.Error(err.Error())
+		}
+	})
+	env := envelop{"message": "an email will be sent to the new address containing confirmation instructions"}
+	if err = app.writeJSON(w, env, http.StatusAccepted, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlainText string `json:"token"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateTokenPlainText(v, input.TokenPlainText); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	usr, err := app.models.Users.GetForToken(data.ScopeEmailChange, input.TokenPlainText)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if usr.ID != app.contextGetUser(r).ID || usr.PendingEmail == nil {
+		v.AddError("token", "invalid or expired email change token")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	usr.Email = *usr.PendingEmail
+	usr.PendingEmail = nil
+	if err = app.models.Users.UpdateUser(usr); err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err = app.models.Tokens.DeleteAllForUser(data.ScopeEmailChange, usr.ID); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = app.writeJSON(w, envelop{"user": usr}, http.StatusOK, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,137 @@
+package data
+
+import (
+	"errors"
+	"github.com/M0hammadUsman/greenlight/internal/validator"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+// APIKey is a long-lived credential limited to a subset of its owner's permissions
+type APIKey struct {
+	ID          int64       `json:"id"`
+	UserID      int64       `json:"-"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Name        string      `json:"name"`
+	PlainText   string      `json:"key,omitempty"` // Only ever set right after the key is created
+	Hash        []byte      `json:"-"`
+	Permissions Permissions `json:"permissions"`
+	Expiry      *time.Time  `json:"expiry"`
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey, ownerPermissions Permissions) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(key.Permissions) >= 1, "permissions", "must contain at least 1 permission")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+	for _, code := range key.Permissions {
+		v.Check(ownerPermissions.Include(code), "permissions", "must be a subset of your own permissions")
+	}
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+}
+
+type APIKeyModel struct {
+	DB *pgxpool.Pool
+}
+
+// New generates the key's plain text & hash, the same way tokens are, and stores it
+func (m APIKeyModel) New(key *APIKey) error {
+	plainText, err := randomPlainText()
+	if err != nil {
+		return err
+	}
+	key.PlainText = plainText
+	key.Hash = HashTokenPlainText(plainText)
+	query := `
+		INSERT INTO api_keys (user_id, name, hash, permissions, expiry)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+		`
+	args := []any{key.UserID, key.Name, key.Hash, key.Permissions, key.Expiry}
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	return m.DB.QueryRow(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+}
+
+func (m APIKeyModel) GetForPlainText(keyPlainText string) (*APIKey, error) {
+	query := `
+		SELECT id, user_id, created_at, name, permissions, expiry
+		FROM api_keys
+		WHERE hash = $1 AND (expiry IS NULL OR expiry > $2)
+		`
+	var key APIKey
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	if err := m.DB.QueryRow(ctx, query, HashTokenPlainText(keyPlainText), time.Now()).Scan(
+		&key.ID,
+		&key.UserID,
+		&key.CreatedAt,
+		&key.Name,
+		&key.Permissions,
+		&key.Expiry,
+	); err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &key, nil
+}
+
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+		SELECT id, user_id, created_at, name, permissions, expiry
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY id
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	rows, _ := m.DB.Query(ctx, query, userID)
+	defer rows.Close()
+	keys := make([]*APIKey, 0)
+	for rows.Next() {
+		var key APIKey
+		if err := rows.Scan(&key.ID, &key.UserID, &key.CreatedAt, &key.Name, &key.Permissions, &key.Expiry); err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (m APIKeyModel) DeleteForUser(id, userID int64) error {
+	query := `
+		DELETE FROM api_keys
+		WHERE id = $1 AND user_id = $2
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	status, err := m.DB.Exec(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	if status.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m APIKeyModel) DeleteAllForUser(userID int64) error {
+	query := `
+		DELETE FROM api_keys
+		WHERE user_id = $1
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	_, err := m.DB.Exec(ctx, query, userID)
+	return err
+}
@@ -0,0 +1,53 @@
+package data
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+const (
+	AuditImpersonationStarted = "impersonation_started"
+	AuditImpersonatedRequest  = "impersonated_request"
+)
+
+// AuditEntry records a request an actor (e.g. an admin) made on behalf of, or about, another user
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	ActorID   int64     `json:"actor_id"`
+	UserID    int64     `json:"user_id"`
+	Action    string    `json:"action"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"` // 0 while the request is still being served
+	IP        string    `json:"ip"`
+}
+
+type AuditModel struct {
+	DB *pgxpool.Pool
+}
+
+func (m AuditModel) Insert(entry *AuditEntry) error {
+	query := `
+		INSERT INTO audit_log (actor_id, user_id, action, method, path, status, ip)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+		`
+	args := []any{entry.ActorID, entry.UserID, entry.Action, entry.Method, entry.Path, entry.Status, entry.IP}
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	return m.DB.QueryRow(ctx, query, args...).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+// UpdateStatus records the response status of an entry inserted before the request was served
+func (m AuditModel) UpdateStatus(entry *AuditEntry) error {
+	query := `
+		UPDATE audit_log
+		SET status = $1
+		WHERE id = $2
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	_, err := m.DB.Exec(ctx, query, entry.Status, entry.ID)
+	return err
+}
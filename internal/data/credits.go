@@ -0,0 +1,101 @@
+package data
+
+import (
+	"fmt"
+	"github.com/M0hammadUsman/greenlight/internal/validator"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	CreditDirector = "director"
+	CreditWriter   = "writer"
+	CreditActor    = "actor"
+)
+
+// Credit links a person to a movie, Character & Billing only apply to actors
+type Credit struct {
+	PersonID  int64  `json:"person_id"`
+	Name      string `json:"name"` // The person's name, read-only
+	Role      string `json:"role"`
+	Character string `json:"character,omitempty"`
+	Billing   *int32 `json:"billing,omitempty"` // Order in the cast list, 1 being top billed
+}
+
+func ValidateCredits(v *validator.Validator, credits []*Credit) {
+	seen := make(map[string]bool, len(credits))
+	for i, c := range credits {
+		key := fmt.Sprintf("credits[%d]", i)
+		v.Check(c.PersonID > 0, key, "person_id must be provided")
+		v.Check(validator.In(c.Role, CreditDirector, CreditWriter, CreditActor), key, "role must be director, writer or actor")
+		if c.Role != CreditActor {
+			v.Check(c.Character == "" && c.Billing == nil, key, "only actors have a character or billing")
+		}
+		v.Check(len(c.Character) <= 500, key, "character must not be more than 500 bytes long")
+		if c.Billing != nil {
+			v.Check(*c.Billing > 0, key, "billing must be greater than zero")
+		}
+		id := fmt.Sprintf("%d/%s", c.PersonID, c.Role)
+		v.Check(!seen[id], key, "must not credit the same person in the same role twice")
+		seen[id] = true
+	}
+}
+
+type CreditModel struct {
+	DB *pgxpool.Pool
+}
+
+func (m CreditModel) GetAllForMovie(movieID int64) ([]*Credit, error) {
+	query := `
+		SELECT c.person_id, p.name, c.role, c.character, c.billing
+		FROM credits c
+		INNER JOIN people p ON p.id = c.person_id
+		WHERE c.movie_id = $1
+		ORDER BY c.role, c.billing NULLS LAST, p.name
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	rows, _ := m.DB.Query(ctx, query, movieID)
+	defer rows.Close()
+	credits := make([]*Credit, 0)
+	for rows.Next() {
+		var c Credit
+		if err := rows.Scan(&c.PersonID, &c.Name, &c.Role, &c.Character, &c.Billing); err != nil {
+			return nil, err
+		}
+		credits = append(credits, &c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return credits, nil
+}
+
+// ReplaceForMovie swaps the movie's credits for the given ones. Every person has to be in the movie's organization,
+// otherwise nothing changes and ErrRecordNotFound is returned.
+func (m CreditModel) ReplaceForMovie(movie *Movie, credits []*Credit) error {
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err = tx.Exec(ctx, `DELETE FROM credits WHERE movie_id = $1`, movie.ID); err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO credits (movie_id, person_id, role, character, billing)
+		SELECT $1, p.id, $3, $4, $5 FROM people p WHERE p.id = $2 AND p.organization_id = $6
+		`
+	for _, c := range credits {
+		args := []any{movie.ID, c.PersonID, c.Role, c.Character, c.Billing, movie.OrganizationID}
+		status, err := tx.Exec(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		if status.RowsAffected() == 0 {
+			return ErrRecordNotFound
+		}
+	}
+	return tx.Commit(ctx)
+}
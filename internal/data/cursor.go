@@ -0,0 +1,65 @@
+package data
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+var cursorEncoding = base64.RawURLEncoding
+
+// Cursor is a position in a keyset paginated listing, the sort key & id of the row the next page starts after (or,
+// going Backward, the previous page ends before). Clients only ever see it encoded & signed so they can't forge one.
+type Cursor struct {
+	Sort     string `json:"s"`
+	Value    string `json:"v"` // The sort column's value as text, cast back to the column's type in the query
+	ID       int64  `json:"i"`
+	Backward bool   `json:"b,omitempty"`
+}
+
+// CursorPage is where a keyset paginated listing can go from the page it returned, Next & Prev are nil at either end.
+// TotalRecords is only counted when asked for.
+type CursorPage struct {
+	Next         *Cursor
+	Prev         *Cursor
+	TotalRecords int
+}
+
+// Encode returns the cursor as an opaque token, base64 JSON followed by its HMAC-SHA256 under key
+func (c Cursor) Encode(key []byte) string {
+	payload, _ := json.Marshal(c)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return cursorEncoding.EncodeToString(payload) + "." + cursorEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// DecodeCursor verifies & decodes a token made by Cursor.Encode
+func DecodeCursor(token string, key []byte) (*Cursor, error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	payload, err := cursorEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	got, err := cursorEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return nil, ErrInvalidCursor
+	}
+	var c Cursor
+	if err = json.Unmarshal(payload, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
@@ -0,0 +1,73 @@
+package data
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var cursorTestKey = []byte(strings.Repeat("k", 32))
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []Cursor{
+		{Sort: "id", Value: "1", ID: 1},
+		{Sort: "-title", Value: "The Matrix", ID: 603},
+		{Sort: "rating", Value: "7.25", ID: 12, Backward: true},
+		{Sort: "title", Value: "", ID: 9},
+	}
+	for _, want := range tests {
+		got, err := DecodeCursor(want.Encode(cursorTestKey), cursorTestKey)
+		if err != nil {
+			t.Fatalf("DecodeCursor(%+v) error = %v", want, err)
+		}
+		if *got != want {
+			t.Errorf("DecodeCursor = %+v, want %+v", *got, want)
+		}
+	}
+}
+
+func TestDecodeCursorRejects(t *testing.T) {
+	token := Cursor{Sort: "id", Value: "10", ID: 10}.Encode(cursorTestKey)
+	payload, sig, _ := strings.Cut(token, ".")
+
+	// The payload of another cursor spliced onto this one's signature
+	otherPayload, _, _ := strings.Cut(Cursor{Sort: "id", Value: "1", ID: 1}.Encode(cursorTestKey), ".")
+
+	// A payload that isn't JSON, correctly signed
+	mac := hmac.New(sha256.New, cursorTestKey)
+	mac.Write([]byte("nope"))
+	notJSON := cursorEncoding.EncodeToString([]byte("nope")) + "." + cursorEncoding.EncodeToString(mac.Sum(nil))
+
+	flipped := []byte(sig)
+	if flipped[0] == 'A' {
+		flipped[0] = 'B'
+	} else {
+		flipped[0] = 'A'
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		key   []byte
+	}{
+		{"tampered payload", otherPayload + "." + sig, cursorTestKey},
+		{"tampered signature", payload + "." + string(flipped), cursorTestKey},
+		{"truncated signature", payload + "." + sig[:10], cursorTestKey},
+		{"missing signature", payload, cursorTestKey},
+		{"empty signature", payload + ".", cursorTestKey},
+		{"payload not base64", "!!." + sig, cursorTestKey},
+		{"signature not base64", payload + ".!!", cursorTestKey},
+		{"signed but not JSON", notJSON, cursorTestKey},
+		{"other key", token, []byte(strings.Repeat("x", 32))},
+		{"empty", "", cursorTestKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeCursor(tt.token, tt.key); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("DecodeCursor error = %v, want %v", err, ErrInvalidCursor)
+			}
+		})
+	}
+}
@@ -52,6 +52,9 @@ type Metadata struct {
 	FirstPage    int `json:"firstPage,omitempty"`
 	LastPage     int `json:"lastPage,omitempty"`
 	TotalRecords int `json:"totalRecords,omitempty"`
+	// NextCursor & PrevCursor are only set by keyset paginated listings
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
 }
 
 func calculateMetadata(totalRecords int, page int, pageSize int) Metadata {
@@ -0,0 +1,175 @@
+package data
+
+import (
+	"errors"
+	"github.com/M0hammadUsman/greenlight/internal/validator"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+// Invitation lets someone register with a set of permissions picked by an admin, even when sign-up is closed
+type Invitation struct {
+	ID          int64       `json:"id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	CreatedBy   *int64      `json:"created_by,omitempty"`
+	Email       string      `json:"email"`
+	PlainText   string      `json:"-"` // Only ever emailed to the invitee
+	Hash        []byte      `json:"-"`
+	Permissions Permissions `json:"permissions"`
+	Expiry      time.Time   `json:"expiry"`
+}
+
+func ValidateInvitation(v *validator.Validator, inv *Invitation, codes []string) {
+	ValidateEmail(v, inv.Email)
+	v.Check(validator.Unique(inv.Permissions), "permissions", "must not contain duplicate values")
+	for _, code := range inv.Permissions {
+		v.Check(validator.In(code, codes...), "permissions", "must only contain known permission codes")
+	}
+	v.Check(inv.Expiry.After(time.Now()), "expiry", "must be in the future")
+}
+
+type InvitationModel struct {
+	DB *pgxpool.Pool
+}
+
+// New generates the invitation's plain text & hash, the same way tokens are, and stores it
+func (m InvitationModel) New(inv *Invitation) error {
+	plainText, err := randomPlainText()
+	if err != nil {
+		return err
+	}
+	inv.PlainText = plainText
+	inv.Hash = HashTokenPlainText(plainText)
+	query := `
+		INSERT INTO invitations (created_by, email, hash, permissions, expiry)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+		`
+	args := []any{inv.CreatedBy, inv.Email, inv.Hash, inv.Permissions, inv.Expiry}
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	return m.DB.QueryRow(ctx, query, args...).Scan(&inv.ID, &inv.CreatedAt)
+}
+
+func (m InvitationModel) GetForPlainText(plainText string) (*Invitation, error) {
+	query := `
+		SELECT id, created_at, created_by, email, permissions, expiry
+		FROM invitations
+		WHERE hash = $1 AND expiry > $2
+		`
+	var inv Invitation
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	if err := m.DB.QueryRow(ctx, query, HashTokenPlainText(plainText), time.Now()).Scan(
+		&inv.ID,
+		&inv.CreatedAt,
+		&inv.CreatedBy,
+		&inv.Email,
+		&inv.Permissions,
+		&inv.Expiry,
+	); err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &inv, nil
+}
+
+func (m InvitationModel) GetAll() ([]*Invitation, error) {
+	query := `
+		SELECT id, created_at, created_by, email, permissions, expiry
+		FROM invitations
+		ORDER BY id
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	rows, _ := m.DB.Query(ctx, query)
+	defer rows.Close()
+	invitations := make([]*Invitation, 0)
+	for rows.Next() {
+		var inv Invitation
+		if err := rows.Scan(&inv.ID, &inv.CreatedAt, &inv.CreatedBy, &inv.Email, &inv.Permissions, &inv.Expiry); err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, &inv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+func (m InvitationModel) Delete(id int64) error {
+	query := `
+		DELETE FROM invitations
+		WHERE id = $1
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	status, err := m.DB.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	if status.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+/*
+Accept uses up the invitation and creates the invited user with the viewer role & its permissions in one
+transaction, so an invitation can't be redeemed twice and isn't lost if the user can't be created (e.g. the email has
+since been taken). The returned ErrRecordNotFound means the invitation was redeemed or revoked in the meantime.
+*/
+func (m InvitationModel) Accept(inv *Invitation, user *User) error {
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	status, err := tx.Exec(ctx, `DELETE FROM invitations WHERE id = $1`, inv.ID)
+	if err != nil {
+		return err
+	}
+	if status.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+	query := `
+		INSERT INTO users (name, email, password, activated)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version
+		`
+	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
+	if err = tx.QueryRow(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version); err != nil {
+		var pgErr *pgconn.PgError
+		switch {
+		case errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "users_email_key":
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+	// Invited users start with the same baseline as registered ones, the invitation's permissions come on top
+	query = `
+		INSERT INTO users_roles
+		SELECT $1, r.id FROM roles r WHERE r.name = $2
+		`
+	if _, err = tx.Exec(ctx, query, user.ID, RoleViewer); err != nil {
+		return err
+	}
+	query = `
+		INSERT INTO users_permissions
+		SELECT $1, p.id FROM permissions p WHERE p.code = ANY($2)
+		`
+	if _, err = tx.Exec(ctx, query, user.ID, inv.Permissions); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
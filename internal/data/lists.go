@@ -0,0 +1,314 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"github.com/M0hammadUsman/greenlight/internal/validator"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"strings"
+	"time"
+)
+
+var (
+	ErrDuplicateListItem = errors.New("duplicate list item")
+	// ErrListOrderMismatch means a reorder didn't name every item of the list exactly once
+	ErrListOrderMismatch = errors.New("list order mismatch")
+)
+
+// List is a user's own collection of movies, e.g. a watchlist. Only public lists can be read by others.
+type List struct {
+	ID          int64       `json:"id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UserID      int64       `json:"user_id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Public      bool        `json:"public"`
+	Version     int32       `json:"version"`
+	Items       []*ListItem `json:"items,omitempty"` // Only loaded when a single list is shown
+}
+
+// ListItem is a movie on a list, items are shown in ascending Position
+type ListItem struct {
+	MovieID  int64     `json:"movie_id"`
+	Title    string    `json:"title"`
+	Year     int32     `json:"year,omitempty,string"`
+	AddedAt  time.Time `json:"added_at"`
+	Position int32     `json:"position"`
+	Note     string    `json:"note,omitempty"`
+}
+
+func ValidateList(v *validator.Validator, list *List) {
+	v.Check(strings.TrimSpace(list.Name) != "", "name", "must be provided & not blank")
+	v.Check(len(list.Name) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(len(list.Description) <= 2000, "description", "must not be more than 2000 bytes long")
+}
+
+func ValidateListItemNote(v *validator.Validator, note string) {
+	v.Check(len(note) <= 2000, "note", "must not be more than 2000 bytes long")
+}
+
+type ListModel struct {
+	DB *pgxpool.Pool
+}
+
+func (m ListModel) Insert(list *List) error {
+	query := `
+		INSERT INTO lists (user_id, name, description, public)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version
+		`
+	args := []any{list.UserID, list.Name, list.Description, list.Public}
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	return m.DB.QueryRow(ctx, query, args...).Scan(&list.ID, &list.CreatedAt, &list.Version)
+}
+
+// GetForUser returns the list only if userID owns it
+func (m ListModel) GetForUser(id, userID int64) (*List, error) {
+	query := `
+		SELECT id, created_at, user_id, name, description, public, version
+		FROM lists
+		WHERE id = $1 AND user_id = $2
+		`
+	return m.get(query, id, userID)
+}
+
+// GetPublic returns the list only if its owner made it public
+func (m ListModel) GetPublic(id int64) (*List, error) {
+	query := `
+		SELECT id, created_at, user_id, name, description, public, version
+		FROM lists
+		WHERE id = $1 AND public
+		`
+	return m.get(query, id)
+}
+
+func (m ListModel) get(query string, args ...any) (*List, error) {
+	var list List
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	err := m.DB.QueryRow(ctx, query, args...).Scan(
+		&list.ID,
+		&list.CreatedAt,
+		&list.UserID,
+		&list.Name,
+		&list.Description,
+		&list.Public,
+		&list.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &list, nil
+}
+
+func (m ListModel) GetAllForUser(userID int64) ([]*List, error) {
+	query := `
+		SELECT id, created_at, user_id, name, description, public, version
+		FROM lists
+		WHERE user_id = $1
+		ORDER BY id
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	rows, _ := m.DB.Query(ctx, query, userID)
+	defer rows.Close()
+	lists := make([]*List, 0)
+	for rows.Next() {
+		var list List
+		err := rows.Scan(&list.ID, &list.CreatedAt, &list.UserID, &list.Name, &list.Description, &list.Public, &list.Version)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, &list)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return lists, nil
+}
+
+func (m ListModel) Update(list *List) error {
+	query := `
+		UPDATE lists
+		SET name = $1, description = $2, public = $3, version = version + 1
+		WHERE id = $4 AND user_id = $5 AND version = $6
+		RETURNING version
+		`
+	args := []any{list.Name, list.Description, list.Public, list.ID, list.UserID, list.Version}
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	if err := m.DB.QueryRow(ctx, query, args...).Scan(&list.Version); err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m ListModel) Delete(id, userID int64) error {
+	query := `
+		DELETE FROM lists
+		WHERE id = $1 AND user_id = $2
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	status, err := m.DB.Exec(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	if status.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// listItemVisible is the condition for an item to be shown to the viewer whose id is the $viewerParam parameter. The
+// movie mustn't be in the trash & must be in the default catalog or one of the viewer's organizations, anonymous
+// viewers (id 0) only see the default catalog so a public list doesn't leak titles from private catalogs. Hidden
+// items come back if the movie is restored or the viewer (re)joins its organization.
+func listItemVisible(viewerParam int) string {
+	return fmt.Sprintf(`mv.deleted_at IS NULL AND (
+			mv.organization_id IN (SELECT organization_id FROM organizations_users WHERE user_id = $%d)
+			OR mv.organization_id = (SELECT id FROM organizations WHERE slug = '%s')
+		)`, viewerParam, DefaultOrganization)
+}
+
+// GetItems returns the items of the list viewerID may see, see listItemVisible
+func (m ListModel) GetItems(listID, viewerID int64) ([]*ListItem, error) {
+	query := fmt.Sprintf(`
+		SELECT li.movie_id, mv.title, mv.year, li.added_at, li.position, li.note
+		FROM lists_items li
+		INNER JOIN movies mv ON mv.id = li.movie_id
+		WHERE li.list_id = $1 AND %v
+		ORDER BY li.position
+		`, listItemVisible(2))
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	rows, _ := m.DB.Query(ctx, query, listID, viewerID)
+	defer rows.Close()
+	items := make([]*ListItem, 0)
+	for rows.Next() {
+		var item ListItem
+		if err := rows.Scan(&item.MovieID, &item.Title, &item.Year, &item.AddedAt, &item.Position, &item.Note); err != nil {
+			return nil, err
+		}
+		items = append(items, &item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// AddItem appends the movie to the end of the list
+func (m ListModel) AddItem(listID, movieID int64, note string) error {
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	// Locking the list keeps concurrent additions from picking the same position
+	if _, err = tx.Exec(ctx, `SELECT 1 FROM lists WHERE id = $1 FOR UPDATE`, listID); err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO lists_items (list_id, movie_id, position, note)
+		SELECT $1, $2, COALESCE(MAX(position), 0) + 1, $3 FROM lists_items WHERE list_id = $1
+		`
+	if _, err = tx.Exec(ctx, query, listID, movieID, note); err != nil {
+		var pgErr *pgconn.PgError
+		switch {
+		case errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "lists_items_pkey":
+			return ErrDuplicateListItem
+		default:
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func (m ListModel) RemoveItem(listID, movieID int64) error {
+	query := `
+		DELETE FROM lists_items
+		WHERE list_id = $1 AND movie_id = $2
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	status, err := m.DB.Exec(ctx, query, listID, movieID)
+	if err != nil {
+		return err
+	}
+	if status.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Reorder puts the list's items in the order of movieIDs, which must name every item the owner can see exactly once.
+// Hidden items, see listItemVisible, aren't named & keep their relative order after the others.
+func (m ListModel) Reorder(list *List, movieIDs []int64) error {
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err = tx.Exec(ctx, `SELECT 1 FROM lists WHERE id = $1 FOR UPDATE`, list.ID); err != nil {
+		return err
+	}
+	query := fmt.Sprintf(`
+		SELECT COUNT(*)
+		FROM lists_items li
+		INNER JOIN movies mv ON mv.id = li.movie_id
+		WHERE li.list_id = $1 AND %v
+		`, listItemVisible(2))
+	var count int
+	if err = tx.QueryRow(ctx, query, list.ID, list.UserID).Scan(&count); err != nil {
+		return err
+	}
+	if count != len(movieIDs) {
+		return ErrListOrderMismatch
+	}
+	query = fmt.Sprintf(`
+		UPDATE lists_items li
+		SET position = o.position
+		FROM UNNEST($2::BIGINT[]) WITH ORDINALITY AS o(movie_id, position), movies mv
+		WHERE li.list_id = $1 AND li.movie_id = o.movie_id AND mv.id = li.movie_id AND %v
+		`, listItemVisible(3))
+	status, err := tx.Exec(ctx, query, list.ID, movieIDs, list.UserID)
+	if err != nil {
+		return err
+	}
+	if status.RowsAffected() != int64(len(movieIDs)) {
+		return ErrListOrderMismatch
+	}
+	query = fmt.Sprintf(`
+		UPDATE lists_items li
+		SET position = h.position
+		FROM (
+			SELECT li.movie_id, $2 + ROW_NUMBER() OVER (ORDER BY li.position) AS position
+			FROM lists_items li
+			INNER JOIN movies mv ON mv.id = li.movie_id
+			WHERE li.list_id = $1 AND NOT (%v)
+		) h
+		WHERE li.list_id = $1 AND li.movie_id = h.movie_id
+		`, listItemVisible(3))
+	if _, err = tx.Exec(ctx, query, list.ID, len(movieIDs), list.UserID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,129 @@
+package data
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"strings"
+	"time"
+)
+
+// LockoutPolicy decides when repeated login failures lock a key out and for how long
+type LockoutPolicy struct {
+	Threshold int           // Failures tolerated before the first lockout
+	Base      time.Duration // Length of the first lockout, doubled by every further failure
+	Max       time.Duration // Upper bound of a single lockout
+	Window    time.Duration // Failures older than this are forgotten
+}
+
+func (p LockoutPolicy) lockout(failures int) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+	d := p.Base
+	for i := p.Threshold; i < failures && d < p.Max; i++ {
+		d *= 2
+	}
+	return min(d, p.Max)
+}
+
+func AccountAttemptKey(email string) string {
+	return "account:" + strings.ToLower(email)
+}
+
+func IPAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+// MFATokenAttemptKey counts wrong codes entered against one mfa pending token
+func MFATokenAttemptKey(tokenPlainText string) string {
+	return "mfa:" + hex.EncodeToString(HashTokenPlainText(tokenPlainText))
+}
+
+type LoginAttemptModel struct {
+	DB *pgxpool.Pool
+}
+
+// LockedUntil returns when the lockout of key ends, or nil if it isn't locked
+func (m LoginAttemptModel) LockedUntil(key string) (*time.Time, error) {
+	query := `
+		SELECT locked_until
+		FROM login_attempts
+		WHERE key = $1 AND locked_until > $2
+		`
+	var lockedUntil *time.Time
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	if err := m.DB.QueryRow(ctx, query, key, time.Now()).Scan(&lockedUntil); err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+	return lockedUntil, nil
+}
+
+// RecordFailure counts a failed login against key and locks it once the policy says so. It returns the failure count
+// and the end of the lockout, which is nil while the key is under the threshold.
+func (m LoginAttemptModel) RecordFailure(key string, p LockoutPolicy) (int, *time.Time, error) {
+	query := `
+		INSERT INTO login_attempts (key, failures, last_failure_at)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE
+		SET failures = CASE
+				WHEN login_attempts.last_failure_at < $3 THEN 1
+				ELSE login_attempts.failures + 1
+			END,
+			last_failure_at = EXCLUDED.last_failure_at
+		RETURNING failures
+		`
+	now := time.Now()
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	var failures int
+	if err := m.DB.QueryRow(ctx, query, key, now, now.Add(-p.Window)).Scan(&failures); err != nil {
+		return 0, nil, err
+	}
+	lockout := p.lockout(failures)
+	if lockout == 0 {
+		return failures, nil, nil
+	}
+	lockedUntil := now.Add(lockout)
+	query = `
+		UPDATE login_attempts
+		SET locked_until = $2
+		WHERE key = $1
+		`
+	if _, err := m.DB.Exec(ctx, query, key, lockedUntil); err != nil {
+		return 0, nil, err
+	}
+	return failures, &lockedUntil, nil
+}
+
+// Reset forgets every failure recorded against key, it's called after a successful login
+func (m LoginAttemptModel) Reset(key string) error {
+	query := `
+		DELETE FROM login_attempts
+		WHERE key = $1
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	_, err := m.DB.Exec(ctx, query, key)
+	return err
+}
+
+// DeleteStale removes keys whose failures are older than the window & that aren't locked anymore
+func (m LoginAttemptModel) DeleteStale(window time.Duration) error {
+	query := `
+		DELETE FROM login_attempts
+		WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until < $2)
+		`
+	now := time.Now()
+	ctx, cancel := newQueryContext(10)
+	defer cancel()
+	_, err := m.DB.Exec(ctx, query, now.Add(-window), now)
+	return err
+}
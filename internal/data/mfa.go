@@ -0,0 +1,216 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"strings"
+	"time"
+)
+
+const recoveryCodeCount = 10
+
+// TOTP is a user's authenticator enrollment, it only guards logins once Confirmed
+type TOTP struct {
+	UserID    int64
+	CreatedAt time.Time
+	Secret    string
+	Confirmed bool
+	LastStep  int64
+}
+
+type MFAModel struct {
+	DB *pgxpool.Pool
+}
+
+// GenerateRecoveryCodes returns a fresh set of one-time recovery codes, formatted as xxxxx-xxxxx-xxxxx-xxxxx-xxxxx-x
+// so they are easier to copy down
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		plainText, err := randomPlainText()
+		if err != nil {
+			return nil, err
+		}
+		var b strings.Builder
+		for j, c := range plainText {
+			if j > 0 && j%5 == 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(c)
+		}
+		codes[i] = b.String()
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode makes the dashes & letter case of a recovery code irrelevant
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+func (m MFAModel) GetTOTP(userID int64) (*TOTP, error) {
+	query := `
+		SELECT user_id, created_at, secret, confirmed, last_step
+		FROM users_totp
+		WHERE user_id = $1
+		`
+	var t TOTP
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	if err := m.DB.QueryRow(ctx, query, userID).Scan(
+		&t.UserID,
+		&t.CreatedAt,
+		&t.Secret,
+		&t.Confirmed,
+		&t.LastStep,
+	); err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+// Enroll stores a new unconfirmed secret, replacing any earlier unconfirmed one. It returns ErrEditConflict if the
+// user already has a confirmed enrollment.
+func (m MFAModel) Enroll(userID int64, secret string) error {
+	query := `
+		INSERT INTO users_totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, created_at = NOW(), last_step = 0
+		WHERE NOT users_totp.confirmed
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	status, err := m.DB.Exec(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+	if status.RowsAffected() == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+// Confirm turns the enrollment on and stores its first set of recovery codes
+func (m MFAModel) Confirm(userID int64, step int64, recoveryCodes []string) error {
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	query := `
+		UPDATE users_totp
+		SET confirmed = TRUE, last_step = $2
+		WHERE user_id = $1 AND NOT confirmed
+		`
+	status, err := tx.Exec(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+	if status.RowsAffected() == 0 {
+		return ErrEditConflict
+	}
+	if err = replaceRecoveryCodes(ctx, tx, userID, recoveryCodes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// UseStep records step as the last accepted one, it fails with ErrEditConflict if that step (or a later one) was
+// already used, which stops a code from being replayed
+func (m MFAModel) UseStep(userID int64, step int64) error {
+	query := `
+		UPDATE users_totp
+		SET last_step = $2
+		WHERE user_id = $1 AND confirmed AND last_step < $2
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	status, err := m.DB.Exec(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+	if status.RowsAffected() == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+// UseRecoveryCode consumes the code, it returns ErrRecordNotFound if the code is unknown or was already used
+func (m MFAModel) UseRecoveryCode(userID int64, code string) error {
+	query := `
+		DELETE FROM recovery_codes
+		WHERE user_id = $1 AND hash = $2
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	status, err := m.DB.Exec(ctx, query, userID, HashTokenPlainText(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if status.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m MFAModel) ReplaceRecoveryCodes(userID int64, recoveryCodes []string) error {
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if err = replaceRecoveryCodes(ctx, tx, userID, recoveryCodes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID int64, recoveryCodes []string) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	hashes := make([][]byte, len(recoveryCodes))
+	for i := range recoveryCodes {
+		hashes[i] = HashTokenPlainText(normalizeRecoveryCode(recoveryCodes[i]))
+	}
+	query := `
+		INSERT INTO recovery_codes (user_id, hash)
+		SELECT $1, UNNEST($2::BYTEA[])
+		`
+	_, err := tx.Exec(ctx, query, userID, hashes)
+	return err
+}
+
+// Disable removes the enrollment together with the recovery codes
+func (m MFAModel) Disable(userID int64) error {
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err = tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	status, err := tx.Exec(ctx, `DELETE FROM users_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	if status.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+	return tx.Commit(ctx)
+}
@@ -11,17 +11,39 @@ var (
 )
 
 type Models struct {
-	Movies      MovieModel
-	Users       UserModel
-	Tokens      TokenModel
-	Permissions PermissionModel
+	Movies        MovieModel
+	Users         UserModel
+	Tokens        TokenModel
+	Permissions   PermissionModel
+	Roles         RoleModel
+	APIKeys       APIKeyModel
+	MFA           MFAModel
+	Logins        LoginAttemptModel
+	Invitations   InvitationModel
+	Organizations OrganizationModel
+	Audit         AuditModel
+	People        PersonModel
+	Credits       CreditModel
+	Reviews       ReviewModel
+	Lists         ListModel
 }
 
-func NewModels(db *pgxpool.Pool) Models {
+func NewModels(db *pgxpool.Pool, passwordParams Argon2idParams, passwordPolicy PasswordPolicy) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Permissions: PermissionModel{DB: db},
+		Movies:        MovieModel{DB: db},
+		Users:         UserModel{DB: db, PasswordParams: passwordParams, PasswordPolicy: passwordPolicy},
+		Tokens:        TokenModel{DB: db},
+		Permissions:   PermissionModel{DB: db},
+		Roles:         RoleModel{DB: db},
+		APIKeys:       APIKeyModel{DB: db},
+		MFA:           MFAModel{DB: db},
+		Logins:        LoginAttemptModel{DB: db},
+		Invitations:   InvitationModel{DB: db},
+		Organizations: OrganizationModel{DB: db},
+		Audit:         AuditModel{DB: db},
+		People:        PersonModel{DB: db},
+		Credits:       CreditModel{DB: db},
+		Reviews:       ReviewModel{DB: db},
+		Lists:         ListModel{DB: db},
 	}
 }
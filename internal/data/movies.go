@@ -6,6 +6,8 @@ import (
 	"github.com/M0hammadUsman/greenlight/internal/validator"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -18,6 +20,28 @@ type Movie struct {
 	Runtime   Runtime   `json:"runtime,omitempty"`
 	Genres    []string  `json:"genres,omitempty"`
 	Version   int32     `json:"version"`
+	CreatedBy *int64    `json:"created_by,omitempty"` // nil for movies added before ownership was recorded
+	// OrganizationID is the organization whose catalog the movie is in, every query is scoped by it
+	OrganizationID int64 `json:"organization_id"`
+	// Rating is the average review score, kept up to date along with Votes by ReviewModel
+	Rating float64 `json:"rating"`
+	Votes  int32   `json:"votes"`
+	// Credits are only loaded when a single movie is shown
+	Credits []*Credit `json:"credits,omitempty"`
+	// DeletedAt & DeletedBy are only set on movies in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy *int64     `json:"deleted_by,omitempty"`
+}
+
+// MovieEditor is who's changing a movie, only a moderator may change movies created by someone else
+type MovieEditor struct {
+	UserID    int64
+	Moderator bool
+}
+
+// CanEdit is the ownership policy, Update & Delete enforce the same rule in their queries
+func (e MovieEditor) CanEdit(movie *Movie) bool {
+	return e.Moderator || (movie.CreatedBy != nil && *movie.CreatedBy == e.UserID)
 }
 
 func ValidateMovie(v *validator.Validator, movie *Movie) {
@@ -43,26 +67,36 @@ type MovieModel struct {
 
 func (m MovieModel) Insert(movie *Movie) error {
 	query := `
-		INSERT INTO movies (title, year, runtime, genres)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO movies (title, year, runtime, genres, created_by, organization_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id, created_at, version
 		`
-	args := []any{movie.Title, movie.Year, movie.Runtime, movie.Genres}
+	args := []any{movie.Title, movie.Year, movie.Runtime, movie.Genres, movie.CreatedBy, movie.OrganizationID}
 	ctx, cancel := newQueryContext(3)
 	defer cancel()
 	return m.DB.QueryRow(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
 }
 
-func (m MovieModel) Get(id int64) (*Movie, error) {
+func (m MovieModel) Get(orgID, id int64) (*Movie, error) {
+	return m.get(orgID, id, false)
+}
+
+// GetDeleted is Get for movies in the trash
+func (m MovieModel) GetDeleted(orgID, id int64) (*Movie, error) {
+	return m.get(orgID, id, true)
+}
+
+func (m MovieModel) get(orgID, id int64, deleted bool) (*Movie, error) {
 	query := `
-		SELECT id, created_at, title, year, runtime, genres, version 
+		SELECT id, created_at, title, year, runtime, genres, version, created_by, organization_id, rating, votes,
+		deleted_at, deleted_by
 		FROM movies
-		WHERE id = $1
+		WHERE id = $1 AND organization_id = $2 AND (deleted_at IS NOT NULL) = $3
 		`
 	var movie Movie
 	ctx, cancel := newQueryContext(3)
 	defer cancel()
-	err := m.DB.QueryRow(ctx, query, id).Scan(
+	err := m.DB.QueryRow(ctx, query, id, orgID, deleted).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
 		&movie.Title,
@@ -70,6 +104,12 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		&movie.Runtime,
 		&movie.Genres,
 		&movie.Version,
+		&movie.CreatedBy,
+		&movie.OrganizationID,
+		&movie.Rating,
+		&movie.Votes,
+		&movie.DeletedAt,
+		&movie.DeletedBy,
 	)
 	if err != nil {
 		switch {
@@ -82,18 +122,50 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// movieListFilter is the WHERE clause shared by the movie listings, $1 to $4 are the organization, title search,
+// genres & person. Movies in the trash are left out.
+const movieListFilter = `organization_id = $1
+        AND deleted_at IS NULL
+        AND (TO_TSVECTOR('english', title) @@ PLAINTO_TSQUERY('english', $2) OR $2 = '')
+        AND (genres @> $3 OR $3 = '{}')
+        AND (id IN (SELECT movie_id FROM credits WHERE person_id = $4) OR $4 = 0)`
+
+// movieSortTypes are the SQL types of the sortable movie columns, a cursor's value is cast back to them
+var movieSortTypes = map[string]string{
+	"id":      "BIGINT",
+	"title":   "TEXT",
+	"year":    "INTEGER",
+	"runtime": "INTEGER",
+	"rating":  "NUMERIC",
+}
+
+func (movie *Movie) sortValue(column string) string {
+	switch column {
+	case "title":
+		return movie.Title
+	case "year":
+		return strconv.FormatInt(int64(movie.Year), 10)
+	case "runtime":
+		return strconv.FormatInt(int64(movie.Runtime), 10)
+	case "rating":
+		return strconv.FormatFloat(movie.Rating, 'f', -1, 64)
+	default:
+		return strconv.FormatInt(movie.ID, 10)
+	}
+}
+
+// GetAll lists the organization's movies, personID narrows it down to the ones the person is credited on if non-zero
+func (m MovieModel) GetAll(orgID int64, title string, genres []string, personID int64, filters Filters) ([]*Movie, Metadata, error) {
 	query := fmt.Sprintf(`
-		SELECT COUNT(*) OVER(), id, created_at, title, year, runtime, genres, version
+		SELECT COUNT(*) OVER(), id, created_at, title, year, runtime, genres, version, created_by, organization_id, rating, votes
         FROM movies
-        WHERE (TO_TSVECTOR('english', title) @@ PLAINTO_TSQUERY('english', $1) OR $1 = '')
-        AND (genres @> $2 OR $2 = '{}')
+        WHERE %v
         ORDER BY %v %v, id ASC
-		LIMIT $3 OFFSET $4
-        `, filters.sortColumn(), filters.sortDirection())
+		LIMIT $5 OFFSET $6
+        `, movieListFilter, filters.sortColumn(), filters.sortDirection())
 	ctx, cancel := newQueryContext(3)
 	defer cancel()
-	args := []any{title, genres, filters.limit(), filters.offset()}
+	args := []any{orgID, title, genres, personID, filters.limit(), filters.offset()}
 	rows, _ := m.DB.Query(ctx, query, args...)
 	defer rows.Close()
 	totalRecords := 0
@@ -109,6 +181,10 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 			&movie.Runtime,
 			&movie.Genres,
 			&movie.Version,
+			&movie.CreatedBy,
+			&movie.OrganizationID,
+			&movie.Rating,
+			&movie.Votes,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
@@ -122,14 +198,106 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	return movies, metadata, nil
 }
 
-func (m MovieModel) Update(movie *Movie) error {
+// GetAllAfter is the keyset paginated counterpart of GetAll, it returns the page after (or before, going backward)
+// cursor, or the first page when cursor is nil. Ties in the sort column are broken by id in the same direction, so
+// the (column, id) pair orders the rows strictly & no OFFSET is needed. The total count is a separate query, only
+// run when count is set.
+func (m MovieModel) GetAllAfter(orgID int64, title string, genres []string, personID int64, filters Filters, cursor *Cursor, count bool) ([]*Movie, CursorPage, error) {
+	column := filters.sortColumn()
+	sqlType, ok := movieSortTypes[column]
+	if !ok {
+		panic(fmt.Sprint("no cursor type for sort column ", column))
+	}
+	ascending := filters.sortDirection() == "ASC"
+	backward := cursor != nil && cursor.Backward
+	// Going backward scans the opposite way from the cursor & the rows are flipped back afterwards
+	if backward {
+		ascending = !ascending
+	}
+	direction, op := "ASC", ">"
+	if !ascending {
+		direction, op = "DESC", "<"
+	}
+	keyset := "TRUE"
+	args := []any{orgID, title, genres, personID, filters.limit() + 1}
+	if cursor != nil {
+		keyset = fmt.Sprintf("(%v, id) %v ($6::%v, $7)", column, op, sqlType)
+		args = append(args, cursor.Value, cursor.ID)
+	}
+	query := fmt.Sprintf(`
+		SELECT id, created_at, title, year, runtime, genres, version, created_by, organization_id, rating, votes
+        FROM movies
+        WHERE %v
+        AND %v
+        ORDER BY %v %v, id %v
+		LIMIT $5
+        `, movieListFilter, keyset, column, direction, direction)
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	rows, _ := m.DB.Query(ctx, query, args...)
+	defer rows.Close()
+	movies := make([]*Movie, 0)
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			&movie.Genres,
+			&movie.Version,
+			&movie.CreatedBy,
+			&movie.OrganizationID,
+			&movie.Rating,
+			&movie.Votes,
+		)
+		if err != nil {
+			return nil, CursorPage{}, err
+		}
+		movies = append(movies, &movie)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, CursorPage{}, err
+	}
+	// The extra row only tells whether there's anything beyond this page
+	more := len(movies) > filters.limit()
+	if more {
+		movies = movies[:filters.limit()]
+	}
+	if backward {
+		slices.Reverse(movies)
+	}
+	var page CursorPage
+	if len(movies) > 0 {
+		first, last := movies[0], movies[len(movies)-1]
+		if backward || more {
+			page.Next = &Cursor{Sort: filters.Sort, Value: last.sortValue(column), ID: last.ID}
+		}
+		if (backward && more) || (!backward && cursor != nil) {
+			page.Prev = &Cursor{Sort: filters.Sort, Value: first.sortValue(column), ID: first.ID, Backward: true}
+		}
+	}
+	if count {
+		query = fmt.Sprintf(`SELECT COUNT(*) FROM movies WHERE %v`, movieListFilter)
+		if err := m.DB.QueryRow(ctx, query, orgID, title, genres, personID).Scan(&page.TotalRecords); err != nil {
+			return nil, CursorPage{}, err
+		}
+	}
+	return movies, page, nil
+}
+
+func (m MovieModel) Update(movie *Movie, editor MovieEditor) error {
 	query := `
 		UPDATE movies 
 		SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-		WHERE id = $5 AND version = $6
+		WHERE id = $5 AND organization_id = $6 AND version = $7 AND ($8 OR created_by = $9) AND deleted_at IS NULL
 		RETURNING version
 		`
-	args := []any{movie.Title, movie.Year, movie.Runtime, movie.Genres, movie.ID, movie.Version}
+	args := []any{
+		movie.Title, movie.Year, movie.Runtime, movie.Genres,
+		movie.ID, movie.OrganizationID, movie.Version, editor.Moderator, editor.UserID,
+	}
 	ctx, cancel := newQueryContext(3)
 	defer cancel()
 	if err := m.DB.QueryRow(ctx, query, args...).Scan(&movie.Version); err != nil {
@@ -143,14 +311,16 @@ func (m MovieModel) Update(movie *Movie) error {
 	return nil
 }
 
-func (m MovieModel) Delete(id int64) error {
+// Delete moves the movie to the trash, it's hidden everywhere, list entries included, until it's restored or purged
+func (m MovieModel) Delete(orgID, id int64, editor MovieEditor) error {
 	query := `
-		DELETE FROM movies 
-        WHERE id = $1
+		UPDATE movies
+		SET deleted_at = NOW(), deleted_by = $5, version = version + 1
+        WHERE id = $1 AND organization_id = $2 AND ($3 OR created_by = $4) AND deleted_at IS NULL
         `
 	ctx, cancel := newQueryContext(3)
 	defer cancel()
-	status, err := m.DB.Exec(ctx, query, id)
+	status, err := m.DB.Exec(ctx, query, id, orgID, editor.Moderator, editor.UserID, editor.UserID)
 	if err != nil {
 		return err
 	}
@@ -159,3 +329,144 @@ func (m MovieModel) Delete(id int64) error {
 	}
 	return nil
 }
+
+// Restore takes the movie back out of the trash, under the same ownership rule as Delete
+func (m MovieModel) Restore(movie *Movie, editor MovieEditor) error {
+	query := `
+		UPDATE movies
+		SET deleted_at = NULL, deleted_by = NULL, version = version + 1
+		WHERE id = $1 AND organization_id = $2 AND ($3 OR created_by = $4) AND deleted_at IS NOT NULL
+		RETURNING version
+		`
+	args := []any{movie.ID, movie.OrganizationID, editor.Moderator, editor.UserID}
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	if err := m.DB.QueryRow(ctx, query, args...).Scan(&movie.Version); err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	movie.DeletedAt, movie.DeletedBy = nil, nil
+	return nil
+}
+
+// Purge permanently removes a movie that's in the trash, its credits, reviews & list entries go with it through
+// ON DELETE CASCADE
+func (m MovieModel) Purge(orgID, id int64) error {
+	query := `
+		DELETE FROM movies
+		WHERE id = $1 AND organization_id = $2 AND deleted_at IS NOT NULL
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	status, err := m.DB.Exec(ctx, query, id, orgID)
+	if err != nil {
+		return err
+	}
+	if status.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// PurgeDeleted permanently removes every movie that went into the trash before the given time & returns how many
+func (m MovieModel) PurgeDeleted(before time.Time) (int64, error) {
+	query := `
+		DELETE FROM movies
+		WHERE deleted_at < $1
+		`
+	ctx, cancel := newQueryContext(30)
+	defer cancel()
+	status, err := m.DB.Exec(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return status.RowsAffected(), nil
+}
+
+// GetAllDeleted lists the organization's trash
+func (m MovieModel) GetAllDeleted(orgID int64, filters Filters) ([]*Movie, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, created_at, title, year, runtime, genres, version, created_by, organization_id,
+		rating, votes, deleted_at, deleted_by
+		FROM movies
+		WHERE organization_id = $1 AND deleted_at IS NOT NULL
+		ORDER BY %v %v, id ASC
+		LIMIT $2 OFFSET $3
+		`, filters.sortColumn(), filters.sortDirection())
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	rows, _ := m.DB.Query(ctx, query, orgID, filters.limit(), filters.offset())
+	defer rows.Close()
+	totalRecords := 0
+	movies := make([]*Movie, 0)
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			&movie.Genres,
+			&movie.Version,
+			&movie.CreatedBy,
+			&movie.OrganizationID,
+			&movie.Rating,
+			&movie.Votes,
+			&movie.DeletedAt,
+			&movie.DeletedBy,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		movies = append(movies, &movie)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return movies, metadata, nil
+}
+
+func (m MovieModel) GetAllCreatedBy(userID int64) ([]*Movie, error) {
+	query := `
+		SELECT id, created_at, title, year, runtime, genres, version, created_by, organization_id, rating, votes
+		FROM movies
+		WHERE created_by = $1 AND deleted_at IS NULL
+		ORDER BY id
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	rows, _ := m.DB.Query(ctx, query, userID)
+	defer rows.Close()
+	movies := make([]*Movie, 0)
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			&movie.Genres,
+			&movie.Version,
+			&movie.CreatedBy,
+			&movie.OrganizationID,
+			&movie.Rating,
+			&movie.Votes,
+		)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, &movie)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
@@ -0,0 +1,295 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"github.com/M0hammadUsman/greenlight/internal/validator"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"regexp"
+	"time"
+)
+
+// DefaultOrganization is the slug of the organization requests are scoped to when they don't name one, every user
+// joins it on sign-up and it holds every movie added before organizations existed
+const DefaultOrganization = "default"
+
+var (
+	ErrDuplicateSlug = errors.New("duplicate slug")
+	ErrAlreadyMember = errors.New("already a member")
+	slugRX           = regexp.MustCompile("^[a-z0-9](?:[a-z0-9-]{0,48}[a-z0-9])?$")
+)
+
+// Organization owns a movie catalog of its own, members get permissions per organization
+type Organization struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+}
+
+// Member is a user as seen from one organization, Permissions only holds the ones granted within it
+type Member struct {
+	UserID      int64       `json:"user_id"`
+	Name        string      `json:"name,omitempty"`
+	Email       string      `json:"email,omitempty"`
+	JoinedAt    time.Time   `json:"joined_at"`
+	Permissions Permissions `json:"permissions"`
+}
+
+func ValidateOrganization(v *validator.Validator, org *Organization) {
+	v.Check(org.Name != "", "name", "must be provided")
+	v.Check(len(org.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(org.Slug != "", "slug", "must be provided")
+	v.Check(validator.Matches(org.Slug, slugRX), "slug", "must be 1-50 lowercase letters, digits or inner dashes")
+}
+
+type OrganizationModel struct {
+	DB *pgxpool.Pool
+}
+
+// Insert creates the organization with ownerID as its first member, holding codes within it
+func (m OrganizationModel) Insert(org *Organization, ownerID int64, codes ...string) error {
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	query := `
+		INSERT INTO organizations (name, slug)
+		VALUES ($1, $2)
+		RETURNING id, created_at
+		`
+	if err = tx.QueryRow(ctx, query, org.Name, org.Slug).Scan(&org.ID, &org.CreatedAt); err != nil {
+		var pgErr *pgconn.PgError
+		switch {
+		case errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "organizations_slug_key":
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+	query = `
+		INSERT INTO organizations_users (organization_id, user_id)
+		VALUES ($1, $2)
+		`
+	if _, err = tx.Exec(ctx, query, org.ID, ownerID); err != nil {
+		return err
+	}
+	if err = setMemberPermissions(ctx, tx, org.ID, ownerID, codes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (m OrganizationModel) GetBySlug(slug string) (*Organization, error) {
+	query := `
+		SELECT id, created_at, name, slug
+		FROM organizations
+		WHERE slug = $1
+		`
+	var org Organization
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	if err := m.DB.QueryRow(ctx, query, slug).Scan(&org.ID, &org.CreatedAt, &org.Name, &org.Slug); err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &org, nil
+}
+
+func (m OrganizationModel) GetAllForUser(userID int64) ([]*Organization, error) {
+	query := `
+		SELECT o.id, o.created_at, o.name, o.slug
+		FROM organizations o
+		INNER JOIN organizations_users ou ON ou.organization_id = o.id
+		WHERE ou.user_id = $1
+		ORDER BY o.id
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	rows, _ := m.DB.Query(ctx, query, userID)
+	defer rows.Close()
+	orgs := make([]*Organization, 0)
+	for rows.Next() {
+		var org Organization
+		if err := rows.Scan(&org.ID, &org.CreatedAt, &org.Name, &org.Slug); err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, &org)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return orgs, nil
+}
+
+// GetMemberPermissions returns what the user was granted within the organization, ErrRecordNotFound means the user
+// isn't a member at all
+func (m OrganizationModel) GetMemberPermissions(orgID, userID int64) (Permissions, error) {
+	query := `
+		SELECT COALESCE(ARRAY_AGG(p.code ORDER BY p.code) FILTER (WHERE p.code IS NOT NULL), '{}')
+		FROM organizations_users ou
+		LEFT JOIN organizations_users_permissions oup
+		ON oup.organization_id = ou.organization_id AND oup.user_id = ou.user_id
+		LEFT JOIN permissions p ON p.id = oup.permission_id
+		WHERE ou.organization_id = $1 AND ou.user_id = $2
+		GROUP BY ou.organization_id, ou.user_id
+		`
+	var permissions Permissions
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	if err := m.DB.QueryRow(ctx, query, orgID, userID).Scan(&permissions); err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return permissions, nil
+}
+
+func (m OrganizationModel) GetAllMembers(orgID int64) ([]*Member, error) {
+	query := `
+		SELECT u.id, u.name, u.email, ou.created_at,
+		COALESCE(ARRAY_AGG(p.code ORDER BY p.code) FILTER (WHERE p.code IS NOT NULL), '{}')
+		FROM organizations_users ou
+		INNER JOIN users u ON u.id = ou.user_id
+		LEFT JOIN organizations_users_permissions oup
+		ON oup.organization_id = ou.organization_id AND oup.user_id = ou.user_id
+		LEFT JOIN permissions p ON p.id = oup.permission_id
+		WHERE ou.organization_id = $1
+		GROUP BY u.id, ou.created_at
+		ORDER BY u.id
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	rows, _ := m.DB.Query(ctx, query, orgID)
+	defer rows.Close()
+	members := make([]*Member, 0)
+	for rows.Next() {
+		var member Member
+		if err := rows.Scan(&member.UserID, &member.Name, &member.Email, &member.JoinedAt, &member.Permissions); err != nil {
+			return nil, err
+		}
+		members = append(members, &member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// AddMember adds the user to the organization with the given permissions, failing with ErrAlreadyMember if they
+// already are a member
+func (m OrganizationModel) AddMember(orgID, userID int64, codes ...string) error {
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	query := `
+		INSERT INTO organizations_users (organization_id, user_id)
+		VALUES ($1, $2)
+		`
+	if _, err = tx.Exec(ctx, query, orgID, userID); err != nil {
+		var pgErr *pgconn.PgError
+		switch {
+		case errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "organizations_users_pkey":
+			return ErrAlreadyMember
+		default:
+			return err
+		}
+	}
+	if err = setMemberPermissions(ctx, tx, orgID, userID, codes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// UpdateMember replaces the permissions a member holds within the organization, it doesn't add anyone
+func (m OrganizationModel) UpdateMember(orgID, userID int64, codes ...string) error {
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	query := `
+		SELECT 1 FROM organizations_users
+		WHERE organization_id = $1 AND user_id = $2
+		FOR UPDATE
+		`
+	var exists int
+	if err = tx.QueryRow(ctx, query, orgID, userID).Scan(&exists); err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	if err = setMemberPermissions(ctx, tx, orgID, userID, codes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// AddMemberBySlug adds the user to the organization without any permissions of its own, doing nothing if they
+// already are a member
+func (m OrganizationModel) AddMemberBySlug(slug string, userID int64) error {
+	query := `
+		INSERT INTO organizations_users (organization_id, user_id)
+		SELECT o.id, $2 FROM organizations o WHERE o.slug = $1
+		ON CONFLICT DO NOTHING
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	_, err := m.DB.Exec(ctx, query, slug, userID)
+	return err
+}
+
+func (m OrganizationModel) RemoveMember(orgID, userID int64) error {
+	query := `
+		DELETE FROM organizations_users
+		WHERE organization_id = $1 AND user_id = $2
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	status, err := m.DB.Exec(ctx, query, orgID, userID)
+	if err != nil {
+		return err
+	}
+	if status.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// setMemberPermissions replaces the permissions an existing member holds within the organization
+func setMemberPermissions(ctx context.Context, tx pgx.Tx, orgID, userID int64, codes []string) error {
+	query := `
+		DELETE FROM organizations_users_permissions
+		WHERE organization_id = $1 AND user_id = $2
+		`
+	if _, err := tx.Exec(ctx, query, orgID, userID); err != nil {
+		return err
+	}
+	query = `
+		INSERT INTO organizations_users_permissions
+		SELECT $1, $2, p.id FROM permissions p WHERE p.code = ANY($3)
+		`
+	_, err := tx.Exec(ctx, query, orgID, userID, codes)
+	return err
+}
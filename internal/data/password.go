@@ -0,0 +1,161 @@
+package data
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/M0hammadUsman/greenlight/internal/validator"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+var ErrInvalidPasswordHash = errors.New("invalid password hash")
+
+// Argon2idParams are the tunables of new password hashes, every hash records its own so they can change over time
+type Argon2idParams struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// PasswordPolicy holds the checks a new password has to pass on top of ValidatePasswordPlainText. Checks only run
+// when a password is chosen, never on login, so tightening the policy doesn't lock anyone out.
+type PasswordPolicy struct {
+	Checkers []validator.PasswordChecker
+}
+
+// minPersonalInfoLength keeps very short names ("Al") from ruling out half the dictionary
+const minPersonalInfoLength = 3
+
+/*
+ValidatePasswordPolicy checks a newly chosen password against policy and the user's own name and email, which are the
+first thing anyone guessing it would try. The error is only for checkers that failed to run (e.g. an unreadable
+file), a rejected password is reported through v like any other validation failure.
+*/
+func ValidatePasswordPolicy(v *validator.Validator, policy PasswordPolicy, password string, user *User) error {
+	lower := strings.ToLower(password)
+	personal := strings.Fields(strings.ToLower(user.Name))
+	if local, _, ok := strings.Cut(strings.ToLower(user.Email), "@"); ok {
+		personal = append(personal, local)
+	}
+	for _, s := range personal {
+		if len(s) >= minPersonalInfoLength && strings.Contains(lower, s) {
+			v.AddError("password", "must not contain your name or email address")
+			return nil
+		}
+	}
+	for _, c := range policy.Checkers {
+		compromised, err := c.Compromised(password)
+		if err != nil {
+			return err
+		}
+		if compromised {
+			v.AddError("password", "is too common or has appeared in a data breach, please choose another")
+			return nil
+		}
+	}
+	return nil
+}
+
+const argon2idPrefix = "$argon2id$"
+
+/*
+Hashes are stored in the PHC string format, $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>, next to legacy bcrypt hashes
+($2a$/$2b$...) written before Argon2id was introduced. The prefix tells the two apart, and legacy hashes are upgraded
+the next time their owner logs in.
+*/
+type password struct {
+	plainText *string
+	hash      []byte
+}
+
+// Set hashes the password with params, the ones configured at startup are UserModel.PasswordParams
+func (p *password) Set(plainTextPassword string, params Argon2idParams) error {
+	salt := make([]byte, params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	key := argon2.IDKey(
+		[]byte(plainTextPassword), salt,
+		params.Iterations, params.Memory, params.Parallelism, params.KeyLength,
+	)
+	p.hash = []byte(fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version,
+		params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key),
+	))
+	p.plainText = &plainTextPassword
+	return nil
+}
+
+func (p *password) Matches(plainTextPassword string) (bool, error) {
+	if !bytes.HasPrefix(p.hash, []byte(argon2idPrefix)) {
+		if err := bcrypt.CompareHashAndPassword(p.hash, []byte(plainTextPassword)); err != nil {
+			switch {
+			case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+				return false, nil
+			default:
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	params, salt, key, err := decodeArgon2idHash(p.hash)
+	if err != nil {
+		return false, err
+	}
+	otherKey := argon2.IDKey(
+		[]byte(plainTextPassword), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength,
+	)
+	return subtle.ConstantTimeCompare(key, otherKey) == 1, nil
+}
+
+// NeedsRehash reports whether the hash was made with an outdated algorithm or other parameters than current. It's only
+// meaningful right after Matches succeeded, when the plain text is at hand to hash again.
+func (p *password) NeedsRehash(current Argon2idParams) bool {
+	if !bytes.HasPrefix(p.hash, []byte(argon2idPrefix)) {
+		return true
+	}
+	params, _, _, err := decodeArgon2idHash(p.hash)
+	if err != nil {
+		return true
+	}
+	return params.Memory != current.Memory ||
+		params.Iterations != current.Iterations ||
+		params.Parallelism != current.Parallelism ||
+		params.KeyLength != current.KeyLength
+}
+
+func decodeArgon2idHash(hash []byte) (Argon2idParams, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=65536,t=3,p=2", salt, key
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 {
+		return Argon2idParams{}, nil, nil, ErrInvalidPasswordHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2idParams{}, nil, nil, ErrInvalidPasswordHash
+	}
+	var params Argon2idParams
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return Argon2idParams{}, nil, nil, ErrInvalidPasswordHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2idParams{}, nil, nil, ErrInvalidPasswordHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return Argon2idParams{}, nil, nil, ErrInvalidPasswordHash
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
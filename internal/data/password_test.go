@@ -0,0 +1,172 @@
+package data
+
+import (
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+)
+
+// testPasswordParams keep the tests fast, they're far too weak for real use
+var testPasswordParams = Argon2idParams{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestPasswordSetMatches(t *testing.T) {
+	var p password
+	if err := p.Set("pa55word-long", testPasswordParams); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(p.hash), "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("hash = %q, want the PHC format with the params used", p.hash)
+	}
+	tests := []struct {
+		plainText string
+		want      bool
+	}{
+		{"pa55word-long", true},
+		{"pa55word-lonG", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		got, err := p.Matches(tt.plainText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Matches(%q) = %v, want %v", tt.plainText, got, tt.want)
+		}
+	}
+
+	var other password
+	if err := other.Set("pa55word-long", testPasswordParams); err != nil {
+		t.Fatal(err)
+	}
+	if string(other.hash) == string(p.hash) {
+		t.Error("two hashes of the same password are equal, the salt isn't random")
+	}
+}
+
+func TestPasswordBcryptFallback(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("pa55word-long"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := password{hash: hash}
+	tests := []struct {
+		plainText string
+		want      bool
+	}{
+		{"pa55word-long", true},
+		{"wrong-password", false},
+	}
+	for _, tt := range tests {
+		got, err := p.Matches(tt.plainText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Matches(%q) = %v, want %v", tt.plainText, got, tt.want)
+		}
+	}
+	if !p.NeedsRehash(testPasswordParams) {
+		t.Error("bcrypt hash doesn't need a rehash")
+	}
+}
+
+func TestDecodeArgon2idHash(t *testing.T) {
+	const salt, key = "c2FsdHNhbHRzYWx0c2FsdA", "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+	tests := []struct {
+		name    string
+		hash    string
+		want    Argon2idParams
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			hash: "$argon2id$v=19$m=65536,t=3,p=2$" + salt + "$" + key,
+			want: Argon2idParams{Memory: 65536, Iterations: 3, Parallelism: 2, SaltLength: 16, KeyLength: 29},
+		},
+		{name: "other version", hash: "$argon2id$v=16$m=65536,t=3,p=2$" + salt + "$" + key, wantErr: true},
+		{name: "missing version", hash: "$argon2id$m=65536,t=3,p=2$" + salt + "$" + key, wantErr: true},
+		{name: "malformed params", hash: "$argon2id$v=19$m=lots,t=3,p=2$" + salt + "$" + key, wantErr: true},
+		{name: "parallelism overflow", hash: "$argon2id$v=19$m=65536,t=3,p=256$" + salt + "$" + key, wantErr: true},
+		{name: "salt not base64", hash: "$argon2id$v=19$m=65536,t=3,p=2$!!$" + key, wantErr: true},
+		{name: "key not base64", hash: "$argon2id$v=19$m=65536,t=3,p=2$" + salt + "$!!", wantErr: true},
+		{name: "missing key", hash: "$argon2id$v=19$m=65536,t=3,p=2$" + salt, wantErr: true},
+		{name: "extra field", hash: "$argon2id$v=19$m=65536,t=3,p=2$" + salt + "$" + key + "$x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, _, err := decodeArgon2idHash([]byte(tt.hash))
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidPasswordHash) {
+					t.Errorf("error = %v, want %v", err, ErrInvalidPasswordHash)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("params = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPasswordMatchesMalformedHash(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("pa55word-long"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		hash string
+		want error
+	}{
+		{"argon2id", "$argon2id$v=19$m=64,t=1,p=1$broken", ErrInvalidPasswordHash},
+		{"bcrypt truncated", string(legacy[:len(legacy)-10]), bcrypt.ErrHashTooShort},
+		{"bcrypt empty", "", bcrypt.ErrHashTooShort},
+		{"bcrypt newer version", "$3" + string(legacy[2:]), bcrypt.HashVersionTooNewError('3')},
+		{"bcrypt bad prefix", "x" + string(legacy[1:]), bcrypt.InvalidHashPrefixError('x')},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := password{hash: []byte(tt.hash)}
+			match, err := p.Matches("pa55word-long")
+			if match || !errors.Is(err, tt.want) {
+				t.Errorf("Matches = (%v, %v), want (false, %v)", match, err, tt.want)
+			}
+			if !p.NeedsRehash(testPasswordParams) {
+				t.Error("malformed hash doesn't need a rehash")
+			}
+		})
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	var p password
+	if err := p.Set("pa55word-long", testPasswordParams); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		change func(*Argon2idParams)
+		want   bool
+	}{
+		{"same params", func(*Argon2idParams) {}, false},
+		{"salt length", func(p *Argon2idParams) { p.SaltLength = 32 }, false},
+		{"memory", func(p *Argon2idParams) { p.Memory = 128 }, true},
+		{"iterations", func(p *Argon2idParams) { p.Iterations = 2 }, true},
+		{"parallelism", func(p *Argon2idParams) { p.Parallelism = 2 }, true},
+		{"key length", func(p *Argon2idParams) { p.KeyLength = 64 }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := testPasswordParams
+			tt.change(&current)
+			if got := p.NeedsRehash(current); got != tt.want {
+				t.Errorf("NeedsRehash = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
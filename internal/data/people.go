@@ -0,0 +1,176 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"github.com/M0hammadUsman/greenlight/internal/validator"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"strings"
+	"time"
+)
+
+// Person is anyone credited on a movie, like movies they belong to one organization's catalog
+type Person struct {
+	ID             int64     `json:"id"`
+	CreatedAt      time.Time `json:"-"`
+	Name           string    `json:"name"`
+	BirthYear      *int32    `json:"birth_year,omitempty"`
+	Bio            string    `json:"bio,omitempty"`
+	Version        int32     `json:"version"`
+	OrganizationID int64     `json:"organization_id"`
+}
+
+func ValidatePerson(v *validator.Validator, person *Person) {
+	v.Check(strings.TrimSpace(person.Name) != "", "name", "must be provided & not blank")
+	v.Check(len(person.Name) <= 500, "name", "must not be more than 500 bytes long")
+	if person.BirthYear != nil {
+		v.Check(*person.BirthYear >= 1800, "birth_year", "must be greater than 1800")
+		v.Check(*person.BirthYear <= int32(time.Now().Year()), "birth_year", "must not be in the future")
+	}
+	v.Check(len(person.Bio) <= 10_000, "bio", "must not be more than 10000 bytes long")
+}
+
+type PersonModel struct {
+	DB *pgxpool.Pool
+}
+
+func (m PersonModel) Insert(person *Person) error {
+	query := `
+		INSERT INTO people (organization_id, name, birth_year, bio)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version
+		`
+	args := []any{person.OrganizationID, person.Name, person.BirthYear, person.Bio}
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	return m.DB.QueryRow(ctx, query, args...).Scan(&person.ID, &person.CreatedAt, &person.Version)
+}
+
+func (m PersonModel) Get(orgID, id int64) (*Person, error) {
+	query := `
+		SELECT id, created_at, name, birth_year, bio, version, organization_id
+		FROM people
+		WHERE id = $1 AND organization_id = $2
+		`
+	var person Person
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	err := m.DB.QueryRow(ctx, query, id, orgID).Scan(
+		&person.ID,
+		&person.CreatedAt,
+		&person.Name,
+		&person.BirthYear,
+		&person.Bio,
+		&person.Version,
+		&person.OrganizationID,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &person, nil
+}
+
+func (m PersonModel) GetAll(orgID int64, name string, filters Filters) ([]*Person, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, created_at, name, birth_year, bio, version, organization_id
+		FROM people
+		WHERE organization_id = $1
+		AND (TO_TSVECTOR('simple', name) @@ PLAINTO_TSQUERY('simple', $2) OR $2 = '')
+		ORDER BY %v %v, id ASC
+		LIMIT $3 OFFSET $4
+		`, filters.sortColumn(), filters.sortDirection())
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	rows, _ := m.DB.Query(ctx, query, orgID, name, filters.limit(), filters.offset())
+	defer rows.Close()
+	totalRecords := 0
+	people := make([]*Person, 0)
+	for rows.Next() {
+		var person Person
+		err := rows.Scan(
+			&totalRecords,
+			&person.ID,
+			&person.CreatedAt,
+			&person.Name,
+			&person.BirthYear,
+			&person.Bio,
+			&person.Version,
+			&person.OrganizationID,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		people = append(people, &person)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return people, metadata, nil
+}
+
+// personEditableBy is the ownership rule for people, as a WHERE condition on people.id: a person credited on a movie
+// someone else created may only be changed by a moderator, since the change shows on that movie too
+const personEditableBy = `($%[1]d OR NOT EXISTS (
+			SELECT 1 FROM credits c
+			INNER JOIN movies mv ON mv.id = c.movie_id
+			WHERE c.person_id = people.id AND mv.created_by IS DISTINCT FROM $%[2]d
+		))`
+
+// CanEdit reports whether editor may change or delete the person, Update & Delete enforce the same rule
+func (m PersonModel) CanEdit(person *Person, editor MovieEditor) (bool, error) {
+	query := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM people WHERE id = $3 AND %v)`, fmt.Sprintf(personEditableBy, 1, 2))
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	var ok bool
+	err := m.DB.QueryRow(ctx, query, editor.Moderator, editor.UserID, person.ID).Scan(&ok)
+	return ok, err
+}
+
+func (m PersonModel) Update(person *Person, editor MovieEditor) error {
+	query := fmt.Sprintf(`
+		UPDATE people
+		SET name = $1, birth_year = $2, bio = $3, version = version + 1
+		WHERE id = $4 AND organization_id = $5 AND version = $6 AND %v
+		RETURNING version
+		`, fmt.Sprintf(personEditableBy, 7, 8))
+	args := []any{
+		person.Name, person.BirthYear, person.Bio,
+		person.ID, person.OrganizationID, person.Version, editor.Moderator, editor.UserID,
+	}
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	if err := m.DB.QueryRow(ctx, query, args...).Scan(&person.Version); err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes the person, their credits go with them through ON DELETE CASCADE
+func (m PersonModel) Delete(orgID, id int64, editor MovieEditor) error {
+	query := fmt.Sprintf(`
+		DELETE FROM people
+		WHERE id = $1 AND organization_id = $2 AND %v
+		`, fmt.Sprintf(personEditableBy, 3, 4))
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	status, err := m.DB.Exec(ctx, query, id, orgID, editor.Moderator, editor.UserID)
+	if err != nil {
+		return err
+	}
+	if status.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -1,12 +1,21 @@
 package data
 
-import "github.com/jackc/pgx/v5/pgxpool"
+import (
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"strings"
+)
 
 type Permissions []string
 
+// Include reports whether code is granted, either literally or through a wildcard such as movies:* or *
 func (p Permissions) Include(code string) bool {
 	for i := range p {
-		if code == p[i] {
+		if code == p[i] || p[i] == "*" {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(p[i], "*"); ok && strings.HasPrefix(code, prefix) {
 			return true
 		}
 	}
@@ -18,12 +27,18 @@ type PermissionModel struct {
 }
 
 func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	// Direct grants plus everything granted through the user's roles
 	query := `
-		SELECT p.code 
+		SELECT p.code
 		FROM permissions p
 		INNER JOIN users_permissions up ON up.permission_id = p.id
-		INNER JOIN users u ON u.id = up.user_id
-		WHERE u.id = $1
+		WHERE up.user_id = $1
+		UNION
+		SELECT p.code
+		FROM permissions p
+		INNER JOIN roles_permissions rp ON rp.permission_id = p.id
+		INNER JOIN users_roles ur ON ur.role_id = rp.role_id
+		WHERE ur.user_id = $1
 		`
 	ctx, cancel := newQueryContext(3)
 	defer cancel()
@@ -53,3 +68,101 @@ func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 	_, err := m.DB.Exec(ctx, query, userID, codes)
 	return err
 }
+
+// GetDirectForUser returns only the permissions granted to the user one by one, leaving out the ones from roles
+func (m PermissionModel) GetDirectForUser(userID int64) (Permissions, error) {
+	query := `
+		SELECT p.code
+		FROM permissions p
+		INNER JOIN users_permissions up ON up.permission_id = p.id
+		WHERE up.user_id = $1
+		ORDER BY p.code
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	rows, _ := m.DB.Query(ctx, query, userID)
+	defer rows.Close()
+	permissions := make(Permissions, 0)
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+func (m PermissionModel) GetAllCodes() ([]string, error) {
+	query := `
+		SELECT code
+		FROM permissions
+		ORDER BY code
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	rows, _ := m.DB.Query(ctx, query)
+	defer rows.Close()
+	codes := make([]string, 0)
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// ReplaceForUser swaps the user's direct grants & roles for the given ones. The change bumps the user's version and
+// fails with ErrEditConflict if it doesn't match, just like UserModel.UpdateUser. It returns the new version.
+func (m PermissionModel) ReplaceForUser(userID int64, version int, codes, roles []string) (int, error) {
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+	query := `
+		UPDATE users
+		SET version = version + 1
+		WHERE id = $1 AND version = $2
+		RETURNING version
+		`
+	if err = tx.QueryRow(ctx, query, userID, version).Scan(&version); err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return 0, ErrEditConflict
+		default:
+			return 0, err
+		}
+	}
+	if _, err = tx.Exec(ctx, `DELETE FROM users_permissions WHERE user_id = $1`, userID); err != nil {
+		return 0, err
+	}
+	if _, err = tx.Exec(ctx, `DELETE FROM users_roles WHERE user_id = $1`, userID); err != nil {
+		return 0, err
+	}
+	query = `
+		INSERT INTO users_permissions
+		SELECT $1, p.id FROM permissions p WHERE p.code = ANY($2)
+		`
+	if _, err = tx.Exec(ctx, query, userID, codes); err != nil {
+		return 0, err
+	}
+	query = `
+		INSERT INTO users_roles
+		SELECT $1, r.id FROM roles r WHERE r.name = ANY($2)
+		`
+	if _, err = tx.Exec(ctx, query, userID, roles); err != nil {
+		return 0, err
+	}
+	return version, tx.Commit(ctx)
+}
@@ -0,0 +1,255 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/M0hammadUsman/greenlight/internal/validator"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+var ErrDuplicateReview = errors.New("duplicate review")
+
+// Review is a user's score for a movie, every user gets one review per movie
+type Review struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	MovieID   int64     `json:"movie_id"`
+	UserID    int64     `json:"user_id"`
+	Score     int32     `json:"score"`
+	Body      string    `json:"body,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Score >= 1, "score", "must be at least 1")
+	v.Check(review.Score <= 10, "score", "must not be more than 10")
+	v.Check(len(review.Body) <= 10_000, "body", "must not be more than 10000 bytes long")
+}
+
+type ReviewModel struct {
+	DB *pgxpool.Pool
+}
+
+func (m ReviewModel) Insert(review *Review) error {
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if err = lockMovie(ctx, tx, review.MovieID); err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO reviews (movie_id, user_id, score, body)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version
+		`
+	args := []any{review.MovieID, review.UserID, review.Score, review.Body}
+	if err = tx.QueryRow(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.Version); err != nil {
+		var pgErr *pgconn.PgError
+		switch {
+		case errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "reviews_movie_id_user_id_key":
+			return ErrDuplicateReview
+		default:
+			return err
+		}
+	}
+	if err = updateMovieRatings(ctx, tx, review.MovieID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (m ReviewModel) GetForUser(movieID, userID int64) (*Review, error) {
+	query := `
+		SELECT id, created_at, movie_id, user_id, score, body, version
+		FROM reviews
+		WHERE movie_id = $1 AND user_id = $2
+		`
+	var review Review
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	err := m.DB.QueryRow(ctx, query, movieID, userID).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.MovieID,
+		&review.UserID,
+		&review.Score,
+		&review.Body,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &review, nil
+}
+
+func (m ReviewModel) GetAllForMovie(movieID int64, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, created_at, movie_id, user_id, score, body, version
+		FROM reviews
+		WHERE movie_id = $1
+		ORDER BY %v %v, id ASC
+		LIMIT $2 OFFSET $3
+		`, filters.sortColumn(), filters.sortDirection())
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	rows, _ := m.DB.Query(ctx, query, movieID, filters.limit(), filters.offset())
+	defer rows.Close()
+	return scanReviews(rows, filters)
+}
+
+func (m ReviewModel) GetAllByUser(userID int64) ([]*Review, error) {
+	query := `
+		SELECT id, created_at, movie_id, user_id, score, body, version
+		FROM reviews
+		WHERE user_id = $1
+		ORDER BY id
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	rows, _ := m.DB.Query(ctx, query, userID)
+	defer rows.Close()
+	reviews := make([]*Review, 0)
+	for rows.Next() {
+		var review Review
+		err := rows.Scan(
+			&review.ID,
+			&review.CreatedAt,
+			&review.MovieID,
+			&review.UserID,
+			&review.Score,
+			&review.Body,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, &review)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return reviews, nil
+}
+
+func scanReviews(rows pgx.Rows, filters Filters) ([]*Review, Metadata, error) {
+	totalRecords := 0
+	reviews := make([]*Review, 0)
+	for rows.Next() {
+		var review Review
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.CreatedAt,
+			&review.MovieID,
+			&review.UserID,
+			&review.Score,
+			&review.Body,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		reviews = append(reviews, &review)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return reviews, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+func (m ReviewModel) Update(review *Review) error {
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if err = lockMovie(ctx, tx, review.MovieID); err != nil {
+		return err
+	}
+	query := `
+		UPDATE reviews
+		SET score = $1, body = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version
+		`
+	args := []any{review.Score, review.Body, review.ID, review.Version}
+	if err = tx.QueryRow(ctx, query, args...).Scan(&review.Version); err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	if err = updateMovieRatings(ctx, tx, review.MovieID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (m ReviewModel) Delete(movieID, userID int64) error {
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if err = lockMovie(ctx, tx, movieID); err != nil {
+		return err
+	}
+	status, err := tx.Exec(ctx, `DELETE FROM reviews WHERE movie_id = $1 AND user_id = $2`, movieID, userID)
+	if err != nil {
+		return err
+	}
+	if status.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+	if err = updateMovieRatings(ctx, tx, movieID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// lockMovie serializes review changes per movie, otherwise two concurrent ones could each recompute the rating
+// without seeing the other
+func lockMovie(ctx context.Context, tx pgx.Tx, movieID int64) error {
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM movies WHERE id = $1 FOR UPDATE`, movieID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// updateMovieRatings recomputes the average rating & vote count stored on the movies. It doesn't bump their
+// version, reviews aren't edits to the movie and mustn't conflict with them.
+func updateMovieRatings(ctx context.Context, tx pgx.Tx, movieIDs ...int64) error {
+	query := `
+		UPDATE movies m
+		SET rating = COALESCE(r.rating, 0), votes = COALESCE(r.votes, 0)
+		FROM (SELECT UNNEST($1::BIGINT[]) AS movie_id) ids
+		LEFT JOIN (
+			SELECT movie_id, ROUND(AVG(score), 2) AS rating, COUNT(*) AS votes
+			FROM reviews
+			WHERE movie_id = ANY($1)
+			GROUP BY movie_id
+		) r ON r.movie_id = ids.movie_id
+		WHERE m.id = ids.movie_id
+		`
+	_, err := tx.Exec(ctx, query, movieIDs)
+	return err
+}
@@ -0,0 +1,97 @@
+package data
+
+import "github.com/jackc/pgx/v5/pgxpool"
+
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// Role is a named group of permissions that can be assigned to users as a whole
+type Role struct {
+	ID          int64       `json:"id"`
+	Name        string      `json:"name"`
+	Permissions Permissions `json:"permissions"`
+}
+
+type RoleModel struct {
+	DB *pgxpool.Pool
+}
+
+func (m RoleModel) GetAll() ([]*Role, error) {
+	query := `
+		SELECT r.id, r.name, COALESCE(ARRAY_AGG(p.code ORDER BY p.code) FILTER (WHERE p.code IS NOT NULL), '{}')
+		FROM roles r
+		LEFT JOIN roles_permissions rp ON rp.role_id = r.id
+		LEFT JOIN permissions p ON p.id = rp.permission_id
+		GROUP BY r.id
+		ORDER BY r.id
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	rows, _ := m.DB.Query(ctx, query)
+	defer rows.Close()
+	roles := make([]*Role, 0)
+	for rows.Next() {
+		var role Role
+		if err := rows.Scan(&role.ID, &role.Name, &role.Permissions); err != nil {
+			return nil, err
+		}
+		roles = append(roles, &role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (m RoleModel) GetAllForUser(userID int64) ([]string, error) {
+	query := `
+		SELECT r.name
+		FROM roles r
+		INNER JOIN users_roles ur ON ur.role_id = r.id
+		WHERE ur.user_id = $1
+		ORDER BY r.id
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	rows, _ := m.DB.Query(ctx, query, userID)
+	defer rows.Close()
+	roles := make([]string, 0)
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (m RoleModel) AddForUser(userID int64, names ...string) error {
+	query := `
+		INSERT INTO users_roles
+		SELECT $1, r.id FROM roles r WHERE r.name = ANY($2)
+		ON CONFLICT DO NOTHING
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	_, err := m.DB.Exec(ctx, query, userID, names)
+	return err
+}
+
+func (m RoleModel) RemoveForUser(userID int64, names ...string) error {
+	query := `
+		DELETE FROM users_roles ur
+		USING roles r
+		WHERE r.id = ur.role_id AND ur.user_id = $1 AND r.name = ANY($2)
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	_, err := m.DB.Exec(ctx, query, userID, names)
+	return err
+}
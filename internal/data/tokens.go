@@ -4,7 +4,9 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base32"
+	"errors"
 	"github.com/M0hammadUsman/greenlight/internal/validator"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"time"
 )
@@ -12,14 +14,46 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeEmailChange    = "email-change"
+	ScopeRefresh        = "refresh"
+	ScopeMFAPending     = "mfa-pending"
+	ScopeMagicLink      = "magic-link"
 )
 
+// ErrTokenReused is returned when an already rotated refresh token is presented again
+var ErrTokenReused = errors.New("token reused")
+
 type Token struct {
 	PlainText string    `json:"token"`
 	Hash      []byte    `json:"-"`
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	IP        string    `json:"-"`
+	UserAgent string    `json:"-"`
+	// Family links every access & refresh token descended from the same login
+	Family []byte `json:"-"`
+	// ImpersonatorID is the admin acting as UserID, only set on tokens issued through impersonation
+	ImpersonatorID *int64 `json:"-"`
+}
+
+// TokenPair is a short-lived access token issued next to the refresh token that can be exchanged for the next pair
+type TokenPair struct {
+	Access  *Token
+	Refresh *Token
+}
+
+// Session describes an authentication token from the point of view of the user who owns it
+type Session struct {
+	ID         int64      `json:"id"`
+	Hash       []byte     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+	Current    bool       `json:"current"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -28,16 +62,30 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 		Expiry: time.Now().Add(ttl),
 		Scope:  scope,
 	}
-	randBytes := make([]byte, 16)
-	if _, err := rand.Read(randBytes); err != nil {
+	plainText, err := randomPlainText()
+	if err != nil {
 		return nil, err
 	}
-	token.PlainText = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randBytes)
-	hash := sha256.Sum256([]byte(token.PlainText))
-	token.Hash = hash[:]
+	token.PlainText = plainText
+	token.Hash = HashTokenPlainText(token.PlainText)
 	return token, nil
 }
 
+// randomPlainText returns 16 random bytes as a 26-character base32 string
+func randomPlainText() (string, error) {
+	randBytes := make([]byte, 16)
+	if _, err := rand.Read(randBytes); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randBytes), nil
+}
+
+// HashTokenPlainText returns the SHA-256 digest under which a token is stored in the tokens table
+func HashTokenPlainText(tokenPlainText string) []byte {
+	hash := sha256.Sum256([]byte(tokenPlainText))
+	return hash[:] // [:] -> the pgx does not support arrays, only slices
+}
+
 func ValidateTokenPlainText(v *validator.Validator, tokenPlainText string) {
 	v.Check(tokenPlainText != "", "token", "must be provided")
 	v.Check(len(tokenPlainText) == 26, "token", "must be 26 bytes long")
@@ -58,16 +106,56 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 
 func (m TokenModel) Insert(token *Token) error {
 	query := `
-		INSERT INTO tokens (hash, user_id, expiry, scope)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO tokens (hash, user_id, expiry, scope, ip, user_agent, family, impersonator_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		`
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []any{
+		token.Hash, token.UserID, token.Expiry, token.Scope, token.IP, token.UserAgent, token.Family, token.ImpersonatorID,
+	}
 	ctx, cancel := newQueryContext(3)
 	defer cancel()
 	_, err := m.DB.Exec(ctx, query, args...)
 	return err
 }
 
+// DeleteByHash deletes the token along with every other token of its family, so revoking an access token also
+// revokes the refresh token it was issued with
+func (m TokenModel) DeleteByHash(scope string, hash []byte) error {
+	query := `
+		DELETE FROM tokens
+		WHERE (scope = $1 AND hash = $2)
+		OR family = (SELECT family FROM tokens WHERE scope = $1 AND hash = $2)
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	status, err := m.DB.Exec(ctx, query, scope, hash)
+	if err != nil {
+		return err
+	}
+	if status.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteFamily deletes every token descended from the same login as family
+func (m TokenModel) DeleteFamily(userID int64, family []byte) error {
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $1 AND family = $2
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	status, err := m.DB.Exec(ctx, query, userID, family)
+	if err != nil {
+		return err
+	}
+	if status.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
 func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	query := `
 		DELETE FROM tokens
@@ -78,3 +166,247 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	_, err := m.DB.Exec(ctx, query, scope, userID)
 	return err
 }
+
+func (m TokenModel) GetAllSessionsForUser(userID int64) ([]*Session, error) {
+	query := `
+		SELECT id, hash, created_at, last_used_at, expiry, ip, user_agent
+		FROM tokens
+		WHERE scope = $1 AND user_id = $2 AND expiry > $3
+		ORDER BY COALESCE(last_used_at, created_at) DESC, id DESC
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	rows, _ := m.DB.Query(ctx, query, ScopeAuthentication, userID, time.Now())
+	defer rows.Close()
+	sessions := make([]*Session, 0)
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.ID,
+			&session.Hash,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.Expiry,
+			&session.IP,
+			&session.UserAgent,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (m TokenModel) DeleteSessionForUser(id, userID int64) error {
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $2
+		AND (
+			(id = $1 AND scope = $3)
+			OR family = (SELECT family FROM tokens WHERE id = $1 AND user_id = $2 AND scope = $3)
+		)
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	status, err := m.DB.Exec(ctx, query, id, userID, ScopeAuthentication)
+	if err != nil {
+		return err
+	}
+	if status.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// UpdateLastUsed writes a batch of last-used timestamps, keyed by token hash, in a single round-trip
+func (m TokenModel) UpdateLastUsed(lastUsed map[string]time.Time) error {
+	if len(lastUsed) == 0 {
+		return nil
+	}
+	hashes := make([][]byte, 0, len(lastUsed))
+	timestamps := make([]time.Time, 0, len(lastUsed))
+	for hash, t := range lastUsed {
+		hashes = append(hashes, []byte(hash))
+		timestamps = append(timestamps, t)
+	}
+	query := `
+		UPDATE tokens t
+		SET last_used_at = u.last_used_at
+		FROM UNNEST($1::BYTEA[], $2::TIMESTAMPTZ[]) AS u(hash, last_used_at)
+		WHERE t.hash = u.hash
+		`
+	ctx, cancel := newQueryContext(5)
+	defer cancel()
+	_, err := m.DB.Exec(ctx, query, hashes, timestamps)
+	return err
+}
+
+// NewForFamily issues a single token belonging to the family. A nil family starts a new one.
+func (m TokenModel) NewForFamily(userID int64, ttl time.Duration, scope string, family []byte, ip, userAgent string) (*Token, error) {
+	family, err := ensureFamily(family)
+	if err != nil {
+		return nil, err
+	}
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+	token.IP = ip
+	token.UserAgent = userAgent
+	token.Family = family
+	err = m.Insert(token)
+	return token, err
+}
+
+// NewImpersonation issues an authentication token for userID that is flagged as used by impersonatorID. It has no
+// refresh token, once it expires the admin has to start impersonating again.
+func (m TokenModel) NewImpersonation(userID, impersonatorID int64, ttl time.Duration, ip, userAgent string) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+	token.IP = ip
+	token.UserAgent = userAgent
+	token.ImpersonatorID = &impersonatorID
+	err = m.Insert(token)
+	return token, err
+}
+
+func ensureFamily(family []byte) ([]byte, error) {
+	if family != nil {
+		return family, nil
+	}
+	family = make([]byte, 16)
+	if _, err := rand.Read(family); err != nil {
+		return nil, err
+	}
+	return family, nil
+}
+
+// NewPair issues an access & refresh token pair in a single transaction. A nil family starts a new one.
+func (m TokenModel) NewPair(userID int64, accessTTL, refreshTTL time.Duration, family []byte, ip, userAgent string) (*TokenPair, error) {
+	family, err := ensureFamily(family)
+	if err != nil {
+		return nil, err
+	}
+	access, err := generateToken(userID, accessTTL, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := generateToken(userID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range []*Token{access, refresh} {
+		token.IP = ip
+		token.UserAgent = userAgent
+		token.Family = family
+	}
+	query := `
+		INSERT INTO tokens (hash, user_id, expiry, scope, ip, user_agent, family)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	for _, t := range []*Token{access, refresh} {
+		args := []any{t.Hash, t.UserID, t.Expiry, t.Scope, t.IP, t.UserAgent, t.Family}
+		if _, err = tx.Exec(ctx, query, args...); err != nil {
+			return nil, err
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return &TokenPair{Access: access, Refresh: refresh}, nil
+}
+
+// Rotate marks the refresh token as used and returns its owner & family so the next pair can be issued. Presenting a
+// token that was already rotated means it leaked, in that case the whole family is revoked and ErrTokenReused returned.
+func (m TokenModel) Rotate(refreshPlainText string) (int64, []byte, error) {
+	hash := HashTokenPlainText(refreshPlainText)
+	query := `
+		UPDATE tokens
+		SET rotated = TRUE
+		WHERE hash = $1 AND scope = $2 AND expiry > $3 AND NOT rotated
+		RETURNING user_id, family
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	var userID int64
+	var family []byte
+	err := m.DB.QueryRow(ctx, query, hash, ScopeRefresh, time.Now()).Scan(&userID, &family)
+	if err == nil {
+		return userID, family, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil, err
+	}
+	query = `
+		DELETE FROM tokens
+		WHERE family = (SELECT family FROM tokens WHERE hash = $1 AND scope = $2 AND rotated)
+		`
+	status, err := m.DB.Exec(ctx, query, hash, ScopeRefresh)
+	if err != nil {
+		return 0, nil, err
+	}
+	if status.RowsAffected() > 0 {
+		return 0, nil, ErrTokenReused
+	}
+	return 0, nil, ErrRecordNotFound
+}
+
+// TokenMetadata is everything about a token except the secret parts, it's what a user's data export contains
+type TokenMetadata struct {
+	Scope      string     `json:"scope"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+}
+
+func (m TokenModel) GetAllMetadataForUser(userID int64) ([]*TokenMetadata, error) {
+	query := `
+		SELECT scope, created_at, last_used_at, expiry, ip, user_agent
+		FROM tokens
+		WHERE user_id = $1
+		ORDER BY id
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	rows, _ := m.DB.Query(ctx, query, userID)
+	defer rows.Close()
+	tokens := make([]*TokenMetadata, 0)
+	for rows.Next() {
+		var t TokenMetadata
+		if err := rows.Scan(&t.Scope, &t.CreatedAt, &t.LastUsedAt, &t.Expiry, &t.IP, &t.UserAgent); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// DeleteAllScopesForUser revokes every token the user has, whatever its scope
+func (m TokenModel) DeleteAllScopesForUser(userID int64) error {
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $1
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	_, err := m.DB.Exec(ctx, query, userID)
+	return err
+}
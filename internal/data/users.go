@@ -1,13 +1,12 @@
 package data
 
 import (
-	"crypto/sha256"
 	"errors"
+	"fmt"
 	"github.com/M0hammadUsman/greenlight/internal/validator"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"golang.org/x/crypto/bcrypt"
 	"time"
 )
 
@@ -24,37 +23,18 @@ type User struct {
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
 	Version   int       `json:"-"`
-}
-
-type password struct {
-	plainText *string
-	hash      []byte
+	// PendingEmail holds an address the user asked to switch to, it only replaces Email once confirmed
+	PendingEmail *string `json:"pending_email,omitempty"`
+	// ImpersonatedBy is the admin acting as this user, only set when the request came with an impersonation token
+	ImpersonatedBy *int64 `json:"impersonated_by,omitempty"`
 }
 
 func (u *User) IsAnonymousUser() bool {
 	return u == AnonymousUser
 }
 
-func (p *password) Set(plainTextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plainTextPassword), 12)
-	if err != nil {
-		return err
-	}
-	p.hash = hash
-	p.plainText = &plainTextPassword
-	return nil
-}
-
-func (p *password) Matches(plainTextPassword string) (bool, error) {
-	if err := bcrypt.CompareHashAndPassword(p.hash, []byte(plainTextPassword)); err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, nil
-		}
-	}
-	return true, nil
+func (u *User) IsImpersonated() bool {
+	return u.ImpersonatedBy != nil
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
@@ -65,7 +45,7 @@ func ValidateEmail(v *validator.Validator, email string) {
 func ValidatePasswordPlainText(v *validator.Validator, password string) {
 	v.Check(password != "", "password", "must be provided")
 	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 500 bytes long")
+	v.Check(len(password) <= 500, "password", "must not be more than 500 bytes long")
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
@@ -85,6 +65,10 @@ func ValidateUser(v *validator.Validator, user *User) {
 
 type UserModel struct {
 	DB *pgxpool.Pool
+	// PasswordParams is what new password hashes are made with & PasswordPolicy what new passwords are checked
+	// against, both come from the startup configuration
+	PasswordParams Argon2idParams
+	PasswordPolicy PasswordPolicy
 }
 
 func (m UserModel) Insert(user *User) error {
@@ -108,9 +92,38 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+func (m UserModel) Get(id int64) (*User, error) {
+	query := `
+		SELECT id, created_at, name, email, password, activated, version, pending_email
+		FROM users
+		WHERE id = $1
+		`
+	var user User
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	if err := m.DB.QueryRow(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&user.PendingEmail,
+	); err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-		SELECT id, created_at, name, email, password, activated, version 
+		SELECT id, created_at, name, email, password, activated, version, pending_email
 		FROM users
 		WHERE email = $1
 		`
@@ -125,6 +138,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
+		&user.PendingEmail,
 	); err != nil {
 		switch {
 		case errors.Is(err, pgx.ErrNoRows):
@@ -139,15 +153,14 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 func (m UserModel) UpdateUser(user *User) error {
 	query := `
 		UPDATE users 
-		SET name = $1, email = $2, password = $3, activated = $4, version = version + 1
-		WHERE id = $5 AND version = $6
+		SET name = $1, email = $2, password = $3, activated = $4, pending_email = $5, version = version + 1
+		WHERE id = $6 AND version = $7
 		RETURNING version
 		`
-	args := []any{user.Name, user.Email, user.Password.hash, user.Activated, user.ID, user.Version}
+	args := []any{user.Name, user.Email, user.Password.hash, user.Activated, user.PendingEmail, user.ID, user.Version}
 	ctx, cancel := newQueryContext(3)
 	defer cancel()
-	var version int
-	if err := m.DB.QueryRow(ctx, query, args...).Scan(&version); err != nil {
+	if err := m.DB.QueryRow(ctx, query, args...).Scan(&user.Version); err != nil {
 		var pgErr *pgconn.PgError
 		switch {
 		case errors.Is(err, pgx.ErrNoRows):
@@ -162,9 +175,8 @@ func (m UserModel) UpdateUser(user *User) error {
 }
 
 func (m UserModel) GetForToken(tokenScope, tokenPlainText string) (*User, error) {
-	tokenHash := sha256.Sum256([]byte(tokenPlainText))
 	query := `
-		SELECT u.id, u.created_at, u.name, u.email, u.password, u.activated, u.version
+		SELECT u.id, u.created_at, u.name, u.email, u.password, u.activated, u.version, u.pending_email, t.impersonator_id
 		FROM users u
 		INNER JOIN tokens t
 		ON u.id = t.user_id
@@ -172,7 +184,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlainText string) (*User, error)
 		AND t.scope = $2
 		AND t.expiry > $3
 		`
-	args := []any{tokenHash[:], tokenScope, time.Now()} // [:] -> the pgx does not support arrays, only slices
+	args := []any{HashTokenPlainText(tokenPlainText), tokenScope, time.Now()}
 	var usr User
 	ctx, cancel := newQueryContext(3)
 	defer cancel()
@@ -184,6 +196,8 @@ func (m UserModel) GetForToken(tokenScope, tokenPlainText string) (*User, error)
 		&usr.Password.hash,
 		&usr.Activated,
 		&usr.Version,
+		&usr.PendingEmail,
+		&usr.ImpersonatedBy,
 	); err != nil {
 		switch {
 		case errors.Is(err, pgx.ErrNoRows):
@@ -194,3 +208,153 @@ func (m UserModel) GetForToken(tokenScope, tokenPlainText string) (*User, error)
 	}
 	return &usr, nil
 }
+
+func (m UserModel) GetAll(name, email string, activated *bool, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, created_at, name, email, password, activated, version, pending_email
+		FROM users
+		WHERE (name ILIKE '%%' || $1 || '%%' OR $1 = '')
+		AND (email::TEXT ILIKE '%%' || $2 || '%%' OR $2 = '')
+		AND (activated = $3 OR $3 IS NULL)
+		ORDER BY %v %v, id ASC
+		LIMIT $4 OFFSET $5
+		`, filters.sortColumn(), filters.sortDirection())
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	args := []any{name, email, activated, filters.limit(), filters.offset()}
+	rows, _ := m.DB.Query(ctx, query, args...)
+	defer rows.Close()
+	totalRecords := 0
+	users := make([]*User, 0)
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Version,
+			&user.PendingEmail,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		users = append(users, &user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return users, metadata, nil
+}
+
+// ScheduleDeletion marks the user for removal by PurgeScheduled once at has passed
+func (m UserModel) ScheduleDeletion(id int64, at time.Time) error {
+	query := `
+		UPDATE users
+		SET delete_after = $2, version = version + 1
+		WHERE id = $1
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	status, err := m.DB.Exec(ctx, query, id, at)
+	if err != nil {
+		return err
+	}
+	if status.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// CancelDeletion reports whether there was a scheduled deletion to cancel
+func (m UserModel) CancelDeletion(id int64) (bool, error) {
+	query := `
+		UPDATE users
+		SET delete_after = NULL, version = version + 1
+		WHERE id = $1 AND delete_after IS NOT NULL
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	status, err := m.DB.Exec(ctx, query, id)
+	if err != nil {
+		return false, err
+	}
+	return status.RowsAffected() > 0, nil
+}
+
+// PurgeScheduled deletes every user whose grace period is over, their tokens, grants & keys go with them through
+// ON DELETE CASCADE. It returns the email addresses of the deleted users.
+func (m UserModel) PurgeScheduled() ([]string, error) {
+	ctx, cancel := newQueryContext(30)
+	defer cancel()
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	now := time.Now()
+	// The users' reviews would go with them anyway, they're deleted first so the ratings they count towards are fixed
+	query := `
+		DELETE FROM reviews
+		WHERE user_id IN (SELECT id FROM users WHERE delete_after < $1)
+		RETURNING movie_id
+		`
+	rows, _ := tx.Query(ctx, query, now)
+	defer rows.Close()
+	movieIDs := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		movieIDs = append(movieIDs, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if err = updateMovieRatings(ctx, tx, movieIDs...); err != nil {
+		return nil, err
+	}
+	query = `
+		DELETE FROM users
+		WHERE delete_after < $1
+		RETURNING email
+		`
+	rows, _ = tx.Query(ctx, query, now)
+	defer rows.Close()
+	emails := make([]string, 0)
+	for rows.Next() {
+		var email string
+		if err = rows.Scan(&email); err != nil {
+			return nil, err
+		}
+		emails = append(emails, email)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return emails, tx.Commit(ctx)
+}
+
+// RehashPassword hashes plainText, the user's current password, again with the current parameters. The version isn't
+// bumped, the password itself didn't change so it mustn't conflict with edits that are in flight. The write only lands
+// while the old hash is still stored, if the password changed in the meantime the rehash is silently dropped.
+func (m UserModel) RehashPassword(user *User, plainText string) error {
+	oldHash := user.Password.hash
+	if err := user.Password.Set(plainText, m.PasswordParams); err != nil {
+		return err
+	}
+	query := `
+		UPDATE users
+		SET password = $1
+		WHERE id = $2 AND password = $3
+		`
+	ctx, cancel := newQueryContext(3)
+	defer cancel()
+	_, err := m.DB.Exec(ctx, query, user.Password.hash, user.ID, oldHash)
+	return err
+}
@@ -0,0 +1,210 @@
+package jwt
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustKey(t *testing.T, id, alg string, fill byte) Key {
+	t.Helper()
+	key, err := ParseKey(id, alg, base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(fill), 32))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func mustKeySet(t *testing.T, signingKID string, keys ...Key) *KeySet {
+	t.Helper()
+	ks, err := NewKeySet(signingKID, keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks
+}
+
+func mustSign(t *testing.T, ks *KeySet, claims Claims) string {
+	t.Helper()
+	token, err := ks.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func validClaims() Claims {
+	now := time.Now()
+	return Claims{
+		Subject:     42,
+		Activated:   true,
+		Permissions: []string{"movies:read"},
+		IssuedAt:    now.Unix(),
+		Expiry:      now.Add(time.Minute).Unix(),
+		Family:      "ZmFtaWx5",
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		alg     string
+		encoded string
+		wantErr bool
+	}{
+		{"HS256", AlgHS256, base64.StdEncoding.EncodeToString(make([]byte, 32)), false},
+		{"HS256 too short", AlgHS256, base64.StdEncoding.EncodeToString(make([]byte, 31)), true},
+		{"EdDSA", AlgEdDSA, base64.StdEncoding.EncodeToString(make([]byte, 32)), false},
+		{"EdDSA wrong seed size", AlgEdDSA, base64.StdEncoding.EncodeToString(make([]byte, 64)), true},
+		{"not base64", AlgHS256, "not base64!", true},
+		{"unsupported algorithm", "RS256", base64.StdEncoding.EncodeToString(make([]byte, 32)), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseKey("k", tt.alg, tt.encoded); (err != nil) != tt.wantErr {
+				t.Errorf("ParseKey error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewKeySet(t *testing.T) {
+	a := mustKey(t, "a", AlgHS256, 'a')
+	if _, err := NewKeySet("a", a, a); err == nil {
+		t.Error("duplicate key ids were accepted")
+	}
+	if _, err := NewKeySet("b", a); err == nil {
+		t.Error("missing signing key was accepted")
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	for _, alg := range []string{AlgHS256, AlgEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			ks := mustKeySet(t, "k1", mustKey(t, "k1", alg, 'k'))
+			want := validClaims()
+			got, err := ks.Verify(mustSign(t, ks, want))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Subject != want.Subject || got.Activated != want.Activated || got.Family != want.Family ||
+				got.Expiry != want.Expiry || strings.Join(got.Permissions, ",") != strings.Join(want.Permissions, ",") {
+				t.Errorf("Verify = %+v, want %+v", *got, want)
+			}
+		})
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	hs := mustKeySet(t, "hs", mustKey(t, "hs", AlgHS256, 'h'))
+	token := mustSign(t, hs, validClaims())
+	parts := strings.Split(token, ".")
+
+	expired := validClaims()
+	expired.Expiry = time.Now().Add(-time.Second).Unix()
+
+	// Same claims with a different subject, keeping the original signature
+	forged := validClaims()
+	forged.Subject = 1
+	forgedParts := strings.Split(mustSign(t, hs, forged), ".")
+
+	otherSecret := mustKeySet(t, "hs", mustKey(t, "hs", AlgHS256, 'x'))
+	unknownKID := mustKeySet(t, "other", mustKey(t, "other", AlgHS256, 'h'))
+	// An EdDSA key set also holding an HS256 key under the kid the header claims, signed as HS256 with the Ed25519
+	// key's kid: the header's alg must not pick how the signature is checked
+	ed := mustKey(t, "ed", AlgEdDSA, 'e')
+	edSet := mustKeySet(t, "ed", ed)
+	confused := mustKeySet(t, "ed", Key{ID: "ed", Alg: AlgHS256, secret: ed.public})
+
+	flipped := []byte(parts[2])
+	if flipped[0] == 'A' {
+		flipped[0] = 'B'
+	} else {
+		flipped[0] = 'A'
+	}
+
+	tests := []struct {
+		name  string
+		ks    *KeySet
+		token string
+		want  error
+	}{
+		{"expired", hs, mustSign(t, hs, expired), ErrExpiredToken},
+		{"tampered claims", hs, parts[0] + "." + forgedParts[1] + "." + parts[2], ErrInvalidToken},
+		{"tampered signature", hs, parts[0] + "." + parts[1] + "." + string(flipped), ErrInvalidToken},
+		{"tampered header", hs, b64.EncodeToString([]byte(`{"alg":"none","typ":"JWT","kid":"hs"}`)) + "." + parts[1] + "." + parts[2], ErrInvalidToken},
+		{"no signature", hs, parts[0] + "." + parts[1] + ".", ErrInvalidToken},
+		{"two parts", hs, parts[0] + "." + parts[1], ErrInvalidToken},
+		{"four parts", hs, token + ".x", ErrInvalidToken},
+		{"header not base64", hs, "!!." + parts[1] + "." + parts[2], ErrInvalidToken},
+		{"header not JSON", hs, b64.EncodeToString([]byte("nope")) + "." + parts[1] + "." + parts[2], ErrInvalidToken},
+		{"wrong secret", otherSecret, token, ErrInvalidToken},
+		{"unknown kid", unknownKID, token, ErrInvalidToken},
+		{"algorithm confusion", edSet, mustSign(t, confused, validClaims()), ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.ks.Verify(tt.token); !errors.Is(err, tt.want) {
+				t.Errorf("Verify error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestKeyRotation walks a rotation from k1 to k2: add k2, switch the signing kid, then drop k1
+func TestKeyRotation(t *testing.T) {
+	k1 := mustKey(t, "k1", AlgHS256, '1')
+	k2 := mustKey(t, "k2", AlgEdDSA, '2')
+
+	before := mustKeySet(t, "k1", k1)
+	added := mustKeySet(t, "k1", k1, k2)
+	switched := mustKeySet(t, "k2", k1, k2)
+	dropped := mustKeySet(t, "k2", k2)
+
+	oldToken := mustSign(t, before, validClaims())
+	newToken := mustSign(t, switched, validClaims())
+
+	tests := []struct {
+		name  string
+		ks    *KeySet
+		token string
+		valid bool
+	}{
+		{"old token after adding k2", added, oldToken, true},
+		{"old token after switching", switched, oldToken, true},
+		{"new token after switching", switched, newToken, true},
+		{"new token before k2 was added", before, newToken, false},
+		{"old token after dropping k1", dropped, oldToken, false},
+		{"new token after dropping k1", dropped, newToken, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.ks.Verify(tt.token); (err == nil) != tt.valid {
+				t.Errorf("Verify error = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+	header, _ := b64.DecodeString(strings.Split(newToken, ".")[0])
+	if !strings.Contains(string(header), `"kid":"k2"`) {
+		t.Errorf("token signed after switching has header %s, want kid k2", header)
+	}
+}
+
+func TestLooksSigned(t *testing.T) {
+	tests := []struct {
+		token string
+		want  bool
+	}{
+		{"a.b.c", true},
+		{"ABCDEFGHIJKLMNOPQRSTUVWXYZ", false},
+		{"a.b", false},
+		{"a.b.c.d", false},
+	}
+	for _, tt := range tests {
+		if got := LooksSigned(tt.token); got != tt.want {
+			t.Errorf("LooksSigned(%q) = %v, want %v", tt.token, got, tt.want)
+		}
+	}
+}
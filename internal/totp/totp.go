@@ -0,0 +1,70 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// RFC 6238 defaults, which is what every authenticator app expects when the otpauth URI doesn't say otherwise
+const (
+	period = 30
+	digits = 6
+	// skew is the number of steps a code may be off by, to tolerate clock drift on the user's device
+	skew = 1
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random 160-bit secret, base32 encoded
+func GenerateSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(secret), nil
+}
+
+// URI builds the otpauth:// URI authenticator apps read, usually from a QR code
+func URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(digits))
+	v.Set("period", fmt.Sprint(period))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// Validate checks code against the secret at time t and returns the time step it matched, so the caller can refuse
+// to accept the same step twice
+func Validate(secret, code string, t time.Time) (int64, bool) {
+	key, err := b32.DecodeString(secret)
+	if err != nil || len(code) != digits {
+		return 0, false
+	}
+	current := t.Unix() / period
+	for step := current - skew; step <= current+skew; step++ {
+		if hmac.Equal([]byte(generate(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func generate(key []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", digits, value%1_000_000)
+}
@@ -0,0 +1,83 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of the RFC 6238 appendix B test vectors, "12345678901234567890" base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// The RFC lists 8-digit codes, these are their last 6 digits
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestGenerateRFC6238(t *testing.T) {
+	key, err := b32.DecodeString(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range rfcVectors {
+		if got := generate(key, tt.unix/period); got != tt.code {
+			t.Errorf("generate at %d = %q, want %q", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tt := range rfcVectors {
+		step, ok := Validate(rfcSecret, tt.code, time.Unix(tt.unix, 0))
+		if !ok || step != tt.unix/period {
+			t.Errorf("Validate(%q) at %d = (%d, %v), want (%d, true)", tt.code, tt.unix, step, ok, tt.unix/period)
+		}
+	}
+
+	// 1111111109 & 1111111111 fall in neighbouring steps, 37037036 & 37037037
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		unix   int64
+		want   bool
+	}{
+		{"previous step", rfcSecret, "081804", 1111111111, true},
+		{"next step", rfcSecret, "050471", 1111111109, true},
+		{"two steps behind", rfcSecret, "081804", 1111111111 + 2*period, false},
+		{"two steps ahead", rfcSecret, "050471", 1111111109 - 2*period, false},
+		{"wrong code", rfcSecret, "000000", 1111111111, false},
+		{"too short", rfcSecret, "50471", 1111111111, false},
+		{"too long", rfcSecret, "14050471", 1111111111, false},
+		{"malformed secret", "not base32!", "050471", 1111111111, false},
+		{"other secret", "JBSWY3DPEHPK3PXP", "050471", 1111111111, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := Validate(tt.secret, tt.code, time.Unix(tt.unix, 0)); ok != tt.want {
+				t.Errorf("Validate = %v, want %v", ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := b32.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("secret %q isn't base32: %v", secret, err)
+	}
+	if len(key) != 20 {
+		t.Errorf("secret is %d bytes, want 20", len(key))
+	}
+}
@@ -0,0 +1,114 @@
+package validator
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/fnv"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PasswordChecker reports whether a password is known to be common or breached. Implementations must work offline.
+type PasswordChecker interface {
+	Compromised(password string) (bool, error)
+}
+
+// HashPrefixDir checks passwords against a local copy of the Pwned Passwords range files. The directory holds one
+// <PREFIX>.txt file per 5-character SHA-1 prefix, each line being the remaining 35 characters and a count
+// (SUFFIX:COUNT), so a lookup only ever reads the one small file sharing the password's prefix.
+type HashPrefixDir struct {
+	Dir string
+}
+
+func (d HashPrefixDir) Compromised(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+	f, err := os.Open(filepath.Join(d.Dir, prefix+".txt"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), ":")
+		if strings.EqualFold(strings.TrimSpace(line), suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// BloomFilter holds a large list of common passwords in a fraction of the memory the list itself would take, at
+// the price of rejecting a small share of passwords that aren't on it. Entries are matched case-insensitively.
+type BloomFilter struct {
+	bits []uint64
+	m    uint64 // Number of bits
+	k    uint64 // Number of hash functions
+}
+
+// NewBloomFilter sizes a filter for n entries with the given false positive rate
+func NewBloomFilter(n int, falsePositiveRate float64) *BloomFilter {
+	n = max(n, 1)
+	m := uint64(math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	k := uint64(max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	return &BloomFilter{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+// LoadBloomFilter builds a filter from a plain text file holding one password per line
+func LoadBloomFilter(path string, falsePositiveRate float64) (*BloomFilter, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(raw), "\n")
+	bf := NewBloomFilter(len(lines), falsePositiveRate)
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); line != "" {
+			bf.Add(line)
+		}
+	}
+	return bf, nil
+}
+
+// locations derives the k bit positions from two halves of one 64-bit hash (Kirsch-Mitzenmacher)
+func (bf *BloomFilter) locations(s string) []uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(strings.ToLower(s)))
+	sum := h.Sum(nil)
+	h1 := uint64(binary.BigEndian.Uint32(sum[:4]))
+	h2 := uint64(binary.BigEndian.Uint32(sum[4:]))
+	locations := make([]uint64, bf.k)
+	for i := range locations {
+		locations[i] = (h1 + uint64(i)*h2) % bf.m
+	}
+	return locations
+}
+
+func (bf *BloomFilter) Add(s string) {
+	for _, l := range bf.locations(s) {
+		bf.bits[l/64] |= 1 << (l % 64)
+	}
+}
+
+func (bf *BloomFilter) Contains(s string) bool {
+	for _, l := range bf.locations(s) {
+		if bf.bits[l/64]&(1<<(l%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (bf *BloomFilter) Compromised(password string) (bool, error) {
+	return bf.Contains(password), nil
+}
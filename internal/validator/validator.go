@@ -2,6 +2,7 @@ package validator
 
 import (
 	"net/mail"
+	"regexp"
 )
 
 type Validator struct {
@@ -46,11 +47,16 @@ func ValidEmail(email string) bool {
 	return true
 }
 
-// Unique returns true if all string values in a slice are unique.
-func Unique(values []string) bool {
-	unique := make(map[string]bool)
+// Unique returns true if all values in a slice are unique.
+func Unique[T comparable](values []T) bool {
+	unique := make(map[T]bool)
 	for _, v := range values {
 		unique[v] = true
 	}
 	return len(values) == len(unique)
 }
+
+// Matches returns true if a string value matches a specific regexp pattern.
+func Matches(value string, rx *regexp.Regexp) bool {
+	return rx.MatchString(value)
+}
@@ -0,0 +1,5 @@
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS id BIGSERIAL UNIQUE;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW();
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMP(0) WITH TIME ZONE;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS user_agent TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS family BYTEA;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS rotated BOOL NOT NULL DEFAULT FALSE;
+CREATE INDEX IF NOT EXISTS tokens_family_idx ON tokens (family);
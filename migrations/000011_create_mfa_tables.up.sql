@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS users_totp (
+    user_id BIGINT PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    secret TEXT NOT NULL,
+    confirmed BOOL NOT NULL DEFAULT FALSE,
+    -- The last accepted time step, a code is never accepted twice
+    last_step BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+    hash BYTEA NOT NULL,
+    PRIMARY KEY (user_id, hash)
+);
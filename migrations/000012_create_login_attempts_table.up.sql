@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS login_attempts (
+    -- Either account:<email> or ip:<address>
+    key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMP(0) WITH TIME ZONE
+);
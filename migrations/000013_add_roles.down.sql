@@ -0,0 +1,14 @@
+-- Turn role grants back into direct grants before the role tables go away, expanding the wildcard
+INSERT INTO users_permissions
+SELECT DISTINCT ur.user_id, g.id
+FROM users_roles ur
+INNER JOIN roles_permissions rp ON rp.role_id = ur.role_id
+INNER JOIN permissions p ON p.id = rp.permission_id
+INNER JOIN permissions g
+ON (p.code <> 'movies:*' AND g.id = p.id) OR (p.code = 'movies:*' AND g.code IN ('movies:read', 'movies:write'))
+ON CONFLICT DO NOTHING;
+
+DROP TABLE IF EXISTS users_roles;
+DROP TABLE IF EXISTS roles_permissions;
+DROP TABLE IF EXISTS roles;
+DELETE FROM permissions WHERE code = 'movies:*';
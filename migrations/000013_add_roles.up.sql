@@ -0,0 +1,56 @@
+CREATE TABLE IF NOT EXISTS roles (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions (
+    role_id BIGINT NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission_id BIGINT NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS users_roles (
+    user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+    role_id BIGINT NOT NULL REFERENCES roles ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+
+INSERT INTO permissions (code)
+VALUES
+('movies:*');
+
+INSERT INTO roles (name)
+VALUES
+('viewer'),
+('editor'),
+('admin');
+
+INSERT INTO roles_permissions
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE (r.name = 'viewer' AND p.code = 'movies:read')
+OR (r.name = 'editor' AND p.code IN ('movies:read', 'movies:write'))
+OR (r.name = 'admin' AND p.code = 'movies:*');
+
+-- Map the existing direct grants onto the matching role, then drop the grants the role now covers
+INSERT INTO users_roles
+SELECT up.user_id, r.id
+FROM users_permissions up
+INNER JOIN permissions p ON p.id = up.permission_id
+INNER JOIN roles r ON r.name = 'editor'
+WHERE p.code = 'movies:write'
+ON CONFLICT DO NOTHING;
+
+INSERT INTO users_roles
+SELECT up.user_id, r.id
+FROM users_permissions up
+INNER JOIN permissions p ON p.id = up.permission_id
+INNER JOIN roles r ON r.name = 'viewer'
+WHERE p.code = 'movies:read'
+AND NOT EXISTS (SELECT 1 FROM users_roles ur WHERE ur.user_id = up.user_id)
+ON CONFLICT DO NOTHING;
+
+DELETE FROM users_permissions up
+USING permissions p
+WHERE p.id = up.permission_id
+AND p.code IN ('movies:read', 'movies:write')
+AND EXISTS (SELECT 1 FROM users_roles ur WHERE ur.user_id = up.user_id);
@@ -0,0 +1,7 @@
+INSERT INTO permissions (code)
+VALUES
+('users:admin');
+
+INSERT INTO roles_permissions
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.code = 'users:admin';
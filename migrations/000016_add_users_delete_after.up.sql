@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS delete_after TIMESTAMP(0) WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS users_delete_after_idx ON users (delete_after) WHERE delete_after IS NOT NULL;
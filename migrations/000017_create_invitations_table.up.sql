@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS invitations (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    created_by BIGINT REFERENCES users ON DELETE SET NULL,
+    email CITEXT NOT NULL,
+    hash BYTEA UNIQUE NOT NULL,
+    permissions TEXT[] NOT NULL,
+    expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL
+);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS movies_organization_id_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS organization_id;
+DROP TABLE IF EXISTS organizations_users_permissions;
+DROP TABLE IF EXISTS organizations_users;
+DROP TABLE IF EXISTS organizations;
+DELETE FROM permissions WHERE code = 'orgs:manage';
@@ -0,0 +1,42 @@
+CREATE TABLE IF NOT EXISTS organizations (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    name TEXT NOT NULL,
+    slug CITEXT UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS organizations_users (
+    organization_id BIGINT NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (organization_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS organizations_users_user_id_idx ON organizations_users (user_id);
+
+-- Permissions a member holds within one organization, on top of the ones granted deployment-wide
+CREATE TABLE IF NOT EXISTS organizations_users_permissions (
+    organization_id BIGINT NOT NULL,
+    user_id BIGINT NOT NULL,
+    permission_id BIGINT NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (organization_id, user_id, permission_id),
+    FOREIGN KEY (organization_id, user_id) REFERENCES organizations_users ON DELETE CASCADE
+);
+
+INSERT INTO permissions (code)
+VALUES
+('orgs:manage');
+
+-- Everything that exists so far becomes the default organization's catalog, with every user a member of it
+INSERT INTO organizations (name, slug)
+VALUES
+('Default', 'default');
+
+INSERT INTO organizations_users (organization_id, user_id)
+SELECT o.id, u.id FROM organizations o, users u
+WHERE o.slug = 'default';
+
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS organization_id BIGINT REFERENCES organizations ON DELETE CASCADE;
+UPDATE movies SET organization_id = (SELECT id FROM organizations WHERE slug = 'default');
+ALTER TABLE movies ALTER COLUMN organization_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS movies_organization_id_idx ON movies (organization_id);
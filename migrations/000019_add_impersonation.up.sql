@@ -0,0 +1,17 @@
+-- Set on authentication tokens an admin issued to act as the token's user
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS impersonator_id BIGINT REFERENCES users ON DELETE CASCADE;
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    actor_id BIGINT REFERENCES users ON DELETE SET NULL,
+    user_id BIGINT REFERENCES users ON DELETE SET NULL,
+    action TEXT NOT NULL,
+    method TEXT NOT NULL,
+    path TEXT NOT NULL,
+    status INT NOT NULL,
+    ip TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_actor_id_idx ON audit_log (actor_id);
+CREATE INDEX IF NOT EXISTS audit_log_user_id_idx ON audit_log (user_id);
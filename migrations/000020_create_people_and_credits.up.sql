@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS people (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    organization_id BIGINT NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    birth_year INTEGER,
+    bio TEXT NOT NULL DEFAULT '',
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS people_organization_id_idx ON people (organization_id);
+CREATE INDEX IF NOT EXISTS people_name_idx ON people USING GIN (to_tsvector('simple', name));
+
+CREATE TABLE IF NOT EXISTS credits (
+    movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+    person_id BIGINT NOT NULL REFERENCES people ON DELETE CASCADE,
+    role TEXT NOT NULL CHECK (role IN ('director', 'writer', 'actor')),
+    character TEXT NOT NULL DEFAULT '',
+    billing INTEGER CHECK (billing > 0),
+    PRIMARY KEY (movie_id, person_id, role)
+);
+
+CREATE INDEX IF NOT EXISTS credits_person_id_idx ON credits (person_id);
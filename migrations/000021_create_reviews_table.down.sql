@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS movies_rating_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS votes;
+ALTER TABLE movies DROP COLUMN IF EXISTS rating;
+DROP TABLE IF EXISTS reviews;
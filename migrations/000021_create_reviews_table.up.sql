@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS reviews (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+    score INTEGER NOT NULL CHECK (score BETWEEN 1 AND 10),
+    body TEXT NOT NULL DEFAULT '',
+    version INTEGER NOT NULL DEFAULT 1,
+    UNIQUE (movie_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS reviews_user_id_idx ON reviews (user_id);
+
+-- Kept up to date whenever a review changes, so listing movies by rating needs no aggregate query
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS rating NUMERIC(4, 2) NOT NULL DEFAULT 0;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS votes INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS movies_rating_idx ON movies (rating);
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS lists (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    public BOOL NOT NULL DEFAULT FALSE,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS lists_user_id_idx ON lists (user_id);
+
+-- Deleting a movie takes its entries out of every list. Positions are unique per list, checked at commit so a
+-- reorder can shuffle them within a transaction.
+CREATE TABLE IF NOT EXISTS lists_items (
+    list_id BIGINT NOT NULL REFERENCES lists ON DELETE CASCADE,
+    movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+    added_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    position INTEGER NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (list_id, movie_id),
+    CONSTRAINT lists_items_position_key UNIQUE (list_id, position) DEFERRABLE INITIALLY DEFERRED
+);
+
+CREATE INDEX IF NOT EXISTS lists_items_movie_id_idx ON lists_items (movie_id);
@@ -0,0 +1,8 @@
+-- Deleted movies stay in the trash, hidden from the catalog, until they're restored or purged
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP(0) WITH TIME ZONE;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS deleted_by BIGINT REFERENCES users ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS movies_deleted_at_idx ON movies (deleted_at) WHERE deleted_at IS NOT NULL;
+
+INSERT INTO permissions (code)
+VALUES
+('movies:purge');